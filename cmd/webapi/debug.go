@@ -0,0 +1,59 @@
+package main
+
+import (
+	"clean/service/metrics"
+	"database/sql"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// debugHandler returns the handler of the debug server: debug variables (/debug/vars), profiler infos (/debug/pprof/)
+// and the metrics in `registry` in the Prometheus text format (/metrics). The database connection pool statistics are
+// published as the `db` variable, and runtime infos as `goroutines`; it must be called only once.
+func debugHandler(dbconn *sql.DB, registry *metrics.Registry) http.Handler {
+	expvar.Publish("db", expvar.Func(func() interface{} {
+		return dbconn.Stats()
+	}))
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// registerDBMetrics adds the statistics of the database connection pool to the metrics
+func registerDBMetrics(registry *metrics.Registry, dbconn *sql.DB) {
+	stat := func(fn func(s sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			return fn(dbconn.Stats())
+		}
+	}
+	registry.NewGaugeFunc("webapi_db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	registry.NewGaugeFunc("webapi_db_open_connections", "Established connections to the database, in use or idle.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	registry.NewGaugeFunc("webapi_db_in_use_connections", "Connections to the database currently in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	registry.NewGaugeFunc("webapi_db_idle_connections", "Idle connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	registry.NewCounterFunc("webapi_db_wait_count_total", "Connections to the database waited for.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	registry.NewCounterFunc("webapi_db_wait_duration_seconds_total", "Time blocked waiting for a new connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	registry.NewCounterFunc("webapi_db_max_idle_closed_total", "Connections closed due to the maximum idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	registry.NewCounterFunc("webapi_db_max_idle_time_closed_total", "Connections closed due to the maximum idle time.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	registry.NewCounterFunc("webapi_db_max_lifetime_closed_total", "Connections closed due to the maximum lifetime.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
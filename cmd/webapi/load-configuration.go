@@ -18,15 +18,36 @@ type WebAPIConfiguration struct {
 	}
 	Web struct {
 		APIHost         string        `conf:"default:0.0.0.0:3000"`
-		DebugHost       string        `conf:"default:0.0.0.0:4000"`
+		DebugHost       string        `conf:"default:127.0.0.1:4000"` // Empty to disable the debug server
 		ReadTimeout     time.Duration `conf:"default:5s"`
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
+		BehindProxy     bool          `conf:"default:false"` // Trust the X-Request-ID header set by the reverse proxy
 	}
-	Debug bool
-	DB    struct {
+	Debug bool // Forces the debug log level
+	Log   struct {
+		Level            string `conf:"default:info"`
+		JSON             bool   `conf:"default:false"`
+		Destination      string `conf:"default:stderr"` // One of stderr, stdout or file
+		File             string // Path of the log file, when the destination is "file"
+		CombinedToStdout bool   `conf:"default:false"` // Also log to stdout, when the destination is "file"
+		MethodName       bool   `conf:"default:false"` // Report the calling function in log entries
+	}
+	Users struct {
+		// RenameGracePeriod is how long the old username of a renamed user redirects to the new one. 0 to disable.
+		RenameGracePeriod time.Duration `conf:"default:720h"`
+		// SessionLifetime is how long the session tokens issued by the login are valid
+		SessionLifetime time.Duration `conf:"default:720h"`
+	}
+	DB struct {
 		Filename string `conf:"default:/tmp/decaf.db"`
 	}
+	Storage struct {
+		Directory string `conf:"default:/tmp/decaf-images"`
+	}
+
+	// Args contains the command line arguments after flags (e.g., `migrate status`)
+	Args conf.Args `yaml:"-"`
 }
 
 // loadConfiguration creates a WebAPIConfiguration starting from flags, environment variables and configuration file.
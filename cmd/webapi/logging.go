@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// newLogger creates the logger as set in the `log` section of the configuration. The returned function stops the
+// logger, closing the log file (if any); it must be called before exiting.
+func newLogger(cfg WebAPIConfiguration) (*logrus.Logger, func(), error) {
+	logger := logrus.New()
+
+	level, err := logrus.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	if cfg.Debug {
+		level = logrus.DebugLevel
+	}
+	logger.SetLevel(level)
+
+	if cfg.Log.JSON {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	logger.SetReportCaller(cfg.Log.MethodName)
+
+	switch cfg.Log.Destination {
+	case "stderr":
+		logger.SetOutput(os.Stderr)
+		return logger, func() {}, nil
+	case "stdout":
+		logger.SetOutput(os.Stdout)
+		return logger, func() {}, nil
+	case "file":
+	default:
+		return nil, nil, fmt.Errorf("invalid log destination %q, expected one of: stderr, stdout, file", cfg.Log.Destination)
+	}
+
+	if cfg.Log.File == "" {
+		return nil, nil, fmt.Errorf("the log file is required when the log destination is \"file\"")
+	}
+	file, err := openLogFile(cfg.Log.File)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Log.CombinedToStdout {
+		logger.SetOutput(io.MultiWriter(file, os.Stdout))
+	} else {
+		logger.SetOutput(file)
+	}
+
+	// Reopen the file on SIGHUP, after it has been moved away (e.g., by logrotate)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := file.reopen(); err != nil {
+				logger.WithError(err).Error("can't reopen the log file")
+			} else {
+				logger.Info("log file reopened")
+			}
+		}
+	}()
+
+	return logger, func() {
+		signal.Stop(hup)
+		close(hup)
+		_ = file.Close()
+	}, nil
+}
+
+// logFile is a log file that can be reopened while logging
+type logFile struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openLogFile opens the log file at `path` for appending, creating it if needed
+func openLogFile(path string) (*logFile, error) {
+	f := &logFile{path: path}
+	if err := f.reopen(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *logFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Write(p)
+}
+
+// reopen closes the log file and opens it again, creating a new file if it has been moved
+func (f *logFile) reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("opening the log file: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		_ = f.file.Close()
+	}
+	f.file = file
+	return nil
+}
+
+// Close closes the log file
+func (f *logFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -4,12 +4,35 @@ It builds a web server around APIs from `service/api`.
 Webapi connects to external resources needed (database) and starts two web servers: the API web server, and the debug.
 Everything is served via the API web server, except debug variables (/debug/vars) and profiler infos (pprof).
 
+The debug web server listens on the `DebugHost` address (set it to an empty string to disable it). Besides the Go
+runtime variables, /debug/vars exports the number of requests for each route (`requests`), the number of uploaded photos
+(`uploads`), the database connection pool statistics (`db`) and the number of goroutines (`goroutines`). /metrics exposes
+the request counters, the requests in flight, the request latency histograms (by route and status code) and the database
+connection pool statistics in the Prometheus text format. The debug server must not be exposed publicly: by default, it
+only listens on the loopback interface (127.0.0.1:4000).
+
 Usage:
 
 	webapi [flags]
+	webapi [flags] migrate status|up|down
 
 Flags and configurations are handled automatically by the code in `load-configuration.go`.
 
+Logging is configured in the `log` section (see `demo/config.yml`): level, JSON output, caller reporting and
+destination (stderr, stdout or a file). The log file is reopened when the SIGHUP signal is received, so that it can be
+rotated (e.g., by logrotate).
+
+The `migrate` command manages the database structure and exits without starting the web servers:
+
+	status
+		Prints the known migrations, and whether they have been applied to the database
+
+	up
+		Applies all pending migrations
+
+	down
+		Reverts the latest applied migration
+
 Return values (exit codes):
 
 	0
@@ -29,15 +52,17 @@ import (
 	"errors"
 	"fmt"
 	"clean/service/api"
+	"clean/service/blobstore"
 	"clean/service/database"
 	"clean/service/globaltime"
+	"clean/service/metrics"
 	"github.com/ardanlabs/conf"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/sirupsen/logrus"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
@@ -56,8 +81,9 @@ func main() {
 // * connects to any external resources (like databases, authenticators, etc.)
 // * creates an instance of the service/api package
 // * starts the principal web server (using the service/api.Router.Handler() for HTTP handlers)
+// * starts the debug web server, if enabled
 // * waits for any termination event: SIGTERM signal (UNIX), non-recoverable server error, etc.
-// * closes the principal web server
+// * closes the principal web server and the debug web server
 func run() error {
 	rand.Seed(globaltime.Now().UnixNano())
 	// Load Configuration and defaults
@@ -70,19 +96,17 @@ func run() error {
 	}
 
 	// Init logging
-	logger := logrus.New()
-	logger.SetOutput(os.Stdout)
-	if cfg.Debug {
-		logger.SetLevel(logrus.DebugLevel)
-	} else {
-		logger.SetLevel(logrus.InfoLevel)
+	logger, closeLogger, err := newLogger(cfg)
+	if err != nil {
+		return fmt.Errorf("configuring the logger: %w", err)
 	}
+	defer closeLogger()
 
 	logger.Infof("application initializing")
 
 	// Start Database
 	logger.Println("initializing database support")
-	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
+	dbconn, err := sql.Open("sqlite3", sqliteDSN(cfg.DB.Filename))
 	if err != nil {
 		logger.WithError(err).Error("error opening SQLite DB")
 		return fmt.Errorf("opening SQLite: %w", err)
@@ -91,12 +115,30 @@ func run() error {
 		logger.Debug("database stopping")
 		_ = dbconn.Close()
 	}()
-	db, err := database.New(dbconn)
+
+	// Run the migration tool instead of the web servers, if requested
+	switch cfg.Args.Num(0) {
+	case "":
+	case "migrate":
+		return runMigrate(dbconn, logger, cfg.Args.Num(1))
+	default:
+		return fmt.Errorf("unknown command %q", cfg.Args.Num(0))
+	}
+
+	db, err := database.New(dbconn, logger)
 	if err != nil {
 		logger.WithError(err).Error("error creating AppDatabase")
 		return fmt.Errorf("creating AppDatabase: %w", err)
 	}
 
+	// Start the photo storage
+	logger.Infof("initializing photo storage in %s", cfg.Storage.Directory)
+	blobs, err := blobstore.NewFileStore(cfg.Storage.Directory)
+	if err != nil {
+		logger.WithError(err).Error("error creating the blob store")
+		return fmt.Errorf("creating the blob store: %w", err)
+	}
+
 	// Start (main) API server
 	logger.Info("initializing API server")
 
@@ -105,14 +147,24 @@ func run() error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	// Make a channel to listen for errors coming from the listeners. Use a
+	// buffered channel so the goroutines can exit if we don't collect these errors.
+	serverErrors := make(chan error, 2)
+
+	// Metrics are served by the debug server
+	registry := metrics.NewRegistry()
+	registerDBMetrics(registry, dbconn)
 
 	// Create the API router
 	apirouter, err := api.New(api.Config{
 		Logger:   logger,
 		Database: db,
+		Blobs:    blobs,
+		Metrics:  registry,
+
+		RenameGracePeriod: cfg.Users.RenameGracePeriod,
+		SessionLifetime:   cfg.Users.SessionLifetime,
+		TrustRequestID:    cfg.Web.BehindProxy,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -145,6 +197,21 @@ func run() error {
 		logger.Infof("stopping API server")
 	}()
 
+	// Start the debug server, if enabled. Profiles can take longer than the API timeouts, so there is no write timeout.
+	var debugserver *http.Server
+	if cfg.Web.DebugHost != "" {
+		debugserver = &http.Server{
+			Addr:              cfg.Web.DebugHost,
+			Handler:           debugHandler(dbconn, registry),
+			ReadHeaderTimeout: cfg.Web.ReadTimeout,
+		}
+		go func() {
+			logger.Infof("debug server listening on %s", debugserver.Addr)
+			serverErrors <- debugserver.ListenAndServe()
+			logger.Infof("stopping debug server")
+		}()
+	}
+
 	// Waiting for shutdown signal or POSIX signals
 	select {
 	case err := <-serverErrors:
@@ -171,6 +238,14 @@ func run() error {
 			err = apiserver.Close()
 		}
 
+		// The debug server is stopped after the API server, so that it's available while requests are completed
+		if debugserver != nil {
+			if derr := debugserver.Shutdown(ctx); derr != nil {
+				logger.WithError(derr).Warning("error during graceful shutdown of debug server")
+				_ = debugserver.Close()
+			}
+		}
+
 		// Log the status of this shutdown.
 		switch {
 		case sig == syscall.SIGSTOP:
@@ -182,3 +257,11 @@ func run() error {
 
 	return nil
 }
+
+// sqliteDSN returns the data source name for the SQLite database file, enabling foreign keys on every connection
+func sqliteDSN(filename string) string {
+	if strings.Contains(filename, "?") {
+		return filename + "&_foreign_keys=on"
+	}
+	return filename + "?_foreign_keys=on"
+}
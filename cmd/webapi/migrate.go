@@ -0,0 +1,58 @@
+package main
+
+import (
+	"clean/service/database"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"text/tabwriter"
+)
+
+// runMigrate executes the `migrate` command. `action` is one of "status", "up" or "down".
+func runMigrate(db *sql.DB, logger logrus.FieldLogger, action string) error {
+	switch action {
+	case "status":
+		return printMigrationStatus(db)
+	case "up":
+		if err := database.MigrateUp(db, logger); err != nil {
+			return fmt.Errorf("migrating up: %w", err)
+		}
+		return printMigrationStatus(db)
+	case "down":
+		if err := database.MigrateDown(db, logger); err != nil {
+			return fmt.Errorf("migrating down: %w", err)
+		}
+		return printMigrationStatus(db)
+	default:
+		return fmt.Errorf("unknown migrate action %q, expected one of: status, up, down", action)
+	}
+}
+
+// printMigrationStatus writes the list of known migrations to the standard output
+func printMigrationStatus(db *sql.DB) error {
+	version, err := database.SchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	latest, err := database.LatestSchemaVersion()
+	if err != nil {
+		return fmt.Errorf("reading migrations: %w", err)
+	}
+	migrations, err := database.Migrations(db)
+	if err != nil {
+		return fmt.Errorf("reading migrations: %w", err)
+	}
+
+	fmt.Printf("database schema version: %d (latest known: %d)\n\n", version, latest) //nolint:forbidigo
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, m := range migrations {
+		status, appliedAt := "pending", ""
+		if m.Applied {
+			status, appliedAt = "applied", m.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		_, _ = fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", m.Version, m.Name, status, appliedAt)
+	}
+	return tw.Flush()
+}
@@ -1,37 +1,111 @@
 package api
 
 import (
+	"clean/service/api/reqcontext"
 	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"net/http"
-	"clean/service/api/reqcontext"
+	"strconv"
+	"time"
 )
 
 // httpRouterHandler is the signature for functions that accepts a reqcontext.RequestContext in addition to those
 // required by the httprouter package.
 type httpRouterHandler func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext)
 
-// wrap parses the request and adds a reqcontext.RequestContext instance related to the request.
-func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+// requestIDHeader is the header with the ID of the request, in responses and (from trusted proxies) in requests
+const requestIDHeader = "X-Request-ID"
+
+// wrap parses the request and adds a reqcontext.RequestContext instance related to the request. `route` identifies the
+// registered route of the handler. Each request is logged in the access log when the handler returns.
+func (rt *_router) wrap(route string, fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		requestsByRoute.Add(route, 1)
+		rt.metrics.inFlight.Add(1)
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		w = rec
+
 		reqUUID, err := uuid.NewV4()
 		if err != nil {
 			rt.baseLogger.WithError(err).Error("can't generate a request UUID")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
 		}
 		var ctx = reqcontext.RequestContext{
 			ReqUUID: reqUUID,
+			ReqID:   reqUUID.String(),
+		}
+		if id := r.Header.Get(requestIDHeader); rt.trustRequestID && validRequestID(id) {
+			ctx.ReqID = id
 		}
+		w.Header().Set(requestIDHeader, ctx.ReqID)
 
 		// Create a request-specific logger
 		ctx.Logger = rt.baseLogger.WithFields(logrus.Fields{
-			"reqid":     ctx.ReqUUID.String(),
+			"reqid":     ctx.ReqID,
 			"remote-ip": r.RemoteAddr,
 		})
 
+		// Log the request and collect its metrics when it's done
+		defer func() {
+			duration := time.Since(start)
+			code := strconv.Itoa(rec.status)
+			rt.metrics.inFlight.Add(-1)
+			rt.metrics.requests.Add(1, route, code)
+			rt.metrics.duration.Observe(duration.Seconds(), route, code)
+
+			ctx.Logger.WithFields(logrus.Fields{
+				"method":   r.Method,
+				"route":    route,
+				"path":     r.URL.Path,
+				"status":   rec.status,
+				"bytes":    rec.written,
+				"duration": duration.Seconds(),
+			}).Info("request handled")
+		}()
+
+		if err != nil {
+			sendError(w, ctx, errInternal, "")
+			return
+		}
+
+		// Resolve the bearer token (if any) into the authenticated user
+		user, err := rt.authenticatedUser(r)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("can't resolve the session token")
+			sendError(w, ctx, errInternal, "")
+			return
+		}
+		ctx.UserID, ctx.Username = user.ID, user.Username
+		if ctx.UserID != "" {
+			ctx.Logger = ctx.Logger.WithField("user", ctx.UserID)
+		}
+
+		// Path parameters have the same constraints in all routes, check them before calling the handler
+		if !validatePath(ps).valid(w, ctx) {
+			return
+		}
+
+		// Links to the old username of a renamed user keep working for a while
+		if rt.redirectRenamedUser(w, r, ps, ctx) {
+			return
+		}
+
 		// Call the next handler in chain (usually, the handler function for the path)
 		fn(w, r, ps, ctx)
 	}
 }
+
+// validRequestID checks that a request ID received from a proxy is safe to log and to send back: at most 128 letters,
+// digits, dots, dashes or underscores
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -7,26 +7,41 @@ import (
 // Handler returns an instance of httprouter.Router that handle APIs registered here
 func (rt *_router) Handler() http.Handler {
 	// Register routes
-	//rt.router.DELETE("/images/:imageurl/like", rt.wrap(rt.unlikePhoto))
+	rt.handle(http.MethodPost, "/session", rt.doLogin) //donezo
+	rt.handle(http.MethodGet, "/users", rt.searchUsers)
+	rt.handle(http.MethodPut, "/users/:user", rt.setMyUserName)
+	rt.handle(http.MethodPut, "/users/:user/follow", rt.followUser)
+	rt.handle(http.MethodDelete, "/users/:user/follow", rt.unfollowUser)
+	rt.handle(http.MethodPut, "/users/:user/ban", rt.banUser)
+	rt.handle(http.MethodDelete, "/users/:user/ban", rt.unbanUser)
+	rt.handle(http.MethodGet, "/users/:user/stream", rt.getMyStream)
+	rt.handle(http.MethodGet, "/users/:user", rt.getUserProfile)
+	rt.handle(http.MethodGet, "/users/:user/photos", rt.userPhotos)
+	rt.handle(http.MethodGet, "/users/:user/followers", rt.getFollowers)
+	rt.handle(http.MethodGet, "/users/:user/following", rt.getFollowing)
 
-	rt.router.POST("/session", rt.wrap(rt.doLogin)) //donezo
-	rt.router.PUT("/users/:username", rt.wrap(rt.setMyUserName))
-	rt.router.PUT("/users/:username/follow", rt.wrap(rt.followUser))
-	rt.router.DELETE("/users/:username/follow", rt.wrap(rt.unfollowUser))
-	rt.router.PUT("/users/:username/ban", rt.wrap(rt.banUser))
-	rt.router.DELETE("/users/:username/ban", rt.wrap(rt.unbanUser))
-	rt.router.GET("/users/:username/stream", rt.wrap(rt.getMyStream))
-	rt.router.GET("/users/:username", rt.wrap(rt.getUserProfile))
-	rt.router.GET("/users/:username/photos", rt.wrap(rt.userPhotos))
+	rt.handle(http.MethodPost, "/images", rt.uploadImage)
+	rt.handle(http.MethodDelete, "/images/:imageid", rt.deletePhoto)
+	rt.handle(http.MethodGet, "/images/:imageid/likes", rt.getLikes)
+	rt.handle(http.MethodPut, "/images/:imageid/likes/:user", rt.likePhoto)
+	rt.handle(http.MethodDelete, "/images/:imageid/likes/:user", rt.unlikePhoto)
+	rt.handle(http.MethodGet, "/images/:imageid/comments", rt.getComments)
+	rt.handle(http.MethodPost, "/images/:imageid/comments", rt.addComment)
+	rt.handle(http.MethodDelete, "/images/:imageid/comments/:commentid", rt.removeComment)
+	rt.handle(http.MethodGet, "/images/:imageid", rt.getImageInfo)
+	rt.handle(http.MethodGet, "/images/:imageid/raw", rt.getImageRaw)
+	rt.handle(http.MethodPut, "/images/:imageid/caption", rt.setCaption)
 
-	rt.router.POST("/images", rt.wrap(rt.uploadImage))
-	rt.router.DELETE("/images/:imageid", rt.wrap(rt.deletePhoto))
-	rt.router.PUT("/images/:imageid/like", rt.wrap(rt.likePhoto))
-	rt.router.PUT("/images/:imageid/comment", rt.wrap(rt.addComment))
-	rt.router.DELETE("/images/:imageid/comment", rt.wrap(rt.removeComment))
-	rt.router.GET("/images/:imageid", rt.wrap(rt.getImageInfo))
+	rt.handle(http.MethodGet, "/hashtags", rt.searchHashtags)
+	rt.handle(http.MethodGet, "/hashtags/:tag/photos", rt.getHashtagPhotos)
 
 	rt.router.GET("/liveness", rt.liveness)
 
 	return rt.router
 }
+
+// handle registers the handler for the given method and path. The path is the route of the requests, used in logs and
+// metrics.
+func (rt *_router) handle(method, path string, fn httpRouterHandler) {
+	rt.router.Handle(method, path, rt.wrap(method+" "+path, fn))
+}
@@ -11,6 +11,8 @@ Example:
 	apirouter, err := api.New(api.Config{
 		Logger:   logger,
 		Database: appdb,
+		Blobs:    blobs,
+		Metrics:  registry,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -37,11 +39,14 @@ See the `main.go` file inside the `cmd/webapi` for a full usage example.
 package api
 
 import (
-	"errors"
+	"clean/service/blobstore"
 	"clean/service/database"
+	"clean/service/metrics"
+	"errors"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"time"
 )
 
 // Config is used to provide dependencies and configuration to the New function.
@@ -51,6 +56,23 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// Blobs is the instance of blobstore.Store where uploaded photos are saved
+	Blobs blobstore.Store
+
+	// Metrics is the registry where request metrics are created. It's optional: if nil, metrics are not exposed.
+	Metrics *metrics.Registry
+
+	// RenameGracePeriod is how long the old username of a renamed user keeps redirecting to the user, and can't be
+	// taken by other users. Zero disables the redirects.
+	RenameGracePeriod time.Duration
+
+	// SessionLifetime is how long the session tokens issued by the login are valid. Zero means defaultSessionLifetime.
+	SessionLifetime time.Duration
+
+	// TrustRequestID enables the use of the X-Request-ID header of requests as request ID. Enable it only behind a
+	// reverse proxy setting (or removing) the header.
+	TrustRequestID bool
 }
 
 // Router is the package API interface representing an API handler builder
@@ -71,6 +93,9 @@ func New(cfg Config) (Router, error) {
 	if cfg.Database == nil {
 		return nil, errors.New("database is required")
 	}
+	if cfg.Blobs == nil {
+		return nil, errors.New("blob store is required")
+	}
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
@@ -78,10 +103,25 @@ func New(cfg Config) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
+	if cfg.SessionLifetime <= 0 {
+		cfg.SessionLifetime = defaultSessionLifetime
+	}
+
+	registry := cfg.Metrics
+	if registry == nil {
+		registry = metrics.NewRegistry()
+	}
+
 	return &_router{
 		router:     router,
 		baseLogger: cfg.Logger,
 		db:         cfg.Database,
+		blobs:      cfg.Blobs,
+		metrics:    newHTTPMetrics(registry),
+
+		renameGracePeriod: cfg.RenameGracePeriod,
+		sessionLifetime:   cfg.SessionLifetime,
+		trustRequestID:    cfg.TrustRequestID,
 	}, nil
 }
 
@@ -93,4 +133,14 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	blobs blobstore.Store
+
+	metrics httpMetrics
+
+	renameGracePeriod time.Duration
+
+	sessionLifetime time.Duration
+
+	trustRequestID bool
 }
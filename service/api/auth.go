@@ -0,0 +1,120 @@
+package api
+
+import (
+	"clean/service/api/reqcontext"
+	"clean/service/database"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sessionTokenBytes is the number of random bytes in a session token
+const sessionTokenBytes = 32
+
+// defaultSessionLifetime is how long session tokens are valid when Config.SessionLifetime is not set
+const defaultSessionLifetime = 30 * 24 * time.Hour
+
+// newSessionToken returns a new random, opaque session token
+func newSessionToken() (string, error) {
+	buf := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// bearerToken returns the token in the "Authorization: Bearer <token>" header, or an empty string if there is none
+func bearerToken(r *http.Request) string {
+	const prefix = "bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// authenticatedUser resolves the bearer token of the request into its owner. Requests without a token, or with an
+// unknown token, are anonymous: an empty user is returned.
+func (rt *_router) authenticatedUser(r *http.Request) (database.User, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return database.User{}, nil
+	}
+	user, err := rt.db.GetSessionUser(token)
+	if errors.Is(err, database.ErrSessionNotFound) {
+		return database.User{}, nil
+	}
+	return user, err
+}
+
+// requireUser replies with 401 Unauthorized if the request is anonymous. It returns true if the handler can go on.
+func requireUser(w http.ResponseWriter, ctx reqcontext.RequestContext) bool {
+	if ctx.UserID == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		sendError(w, ctx, errUnauthorized, "")
+		return false
+	}
+	return true
+}
+
+// requireNotBannedBy replies with 404 Not Found if the authenticated user is banned by the user `ownerID`, so that the
+// resources of the owner look missing to them. It returns true if the handler can go on.
+//
+// Anonymous requests are never banned: bans hide users from each other when they are logged in, but profiles and photos
+// stay readable without a session, as documented in doc/api.yaml. They are not an access control.
+func (rt *_router) requireNotBannedBy(w http.ResponseWriter, ctx reqcontext.RequestContext, ownerID string) bool {
+	banned, err := rt.db.IsBanned(ctx.UserID, ownerID)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to check bans")
+		return false
+	}
+	if banned {
+		sendError(w, ctx, errUserNotFound, "")
+		return false
+	}
+	return true
+}
+
+// requireOwner replies with 401 Unauthorized if the request is anonymous, or with 403 Forbidden if the authenticated
+// user is not `ownerID`. It returns true if the handler can go on.
+func requireOwner(w http.ResponseWriter, ctx reqcontext.RequestContext, ownerID string) bool {
+	if !requireUser(w, ctx) {
+		return false
+	}
+	if ctx.UserID != ownerID {
+		sendError(w, ctx, errForbidden, "")
+		return false
+	}
+	return true
+}
+
+// requirePathOwner is requireOwner for the user in the path, given by ID or by current username. The user doesn't need
+// to be looked up: the authenticated user is the only one allowed.
+func requirePathOwner(w http.ResponseWriter, ps httprouter.Params, ctx reqcontext.RequestContext) bool {
+	if !requireUser(w, ctx) {
+		return false
+	}
+	if ref := ps.ByName("user"); ref != ctx.UserID && ref != ctx.Username {
+		sendError(w, ctx, errForbidden, "")
+		return false
+	}
+	return true
+}
+
+// pathUser looks up the user in the path, given by ID or by current username. It replies with 404 Not Found if there's
+// no such user, or if they banned the authenticated user. It returns the user, and true if the handler can go on.
+func (rt *_router) pathUser(w http.ResponseWriter, ps httprouter.Params, ctx reqcontext.RequestContext) (database.User, bool) {
+	user, err := rt.db.GetUser(ps.ByName("user"))
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve user")
+		return database.User{}, false
+	}
+	if !rt.requireNotBannedBy(w, ctx, user.ID) {
+		return database.User{}, false
+	}
+	return user, true
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"clean/service/api/reqcontext"
+	"clean/service/database"
+	"encoding/json"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+)
+
+// addComment adds a comment by the authenticated user to the image, and returns the new comment
+func (rt *_router) addComment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireUser(w, ctx) {
+		return
+	}
+
+	var requestBody struct {
+		Comment string `json:"comment"`
+	}
+	if !decodeJSON(w, r, ctx, &requestBody) {
+		return
+	}
+	var v validator
+	v.comment("body", "comment", requestBody.Comment)
+	if !v.valid(w, ctx) {
+		return
+	}
+
+	imageID, err := strconv.ParseInt(ps.ByName("imageid"), 10, 64)
+	if err != nil {
+		sendError(w, ctx, errInvalidImageID, "")
+		return
+	}
+	if _, err := rt.db.GetImage(imageID, ctx.UserID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
+		return
+	}
+
+	comment, err := rt.db.AddComment(imageID, ctx.UserID, requestBody.Comment)
+	if errors.Is(err, database.ErrBanned) {
+		sendError(w, ctx, errBanned, "can't comment photos of a banned user")
+		return
+	} else if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to add comment to the image")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(comment)
+}
+
+// removeComment deletes a comment. Only the author of the comment and the owner of the image can delete it.
+func (rt *_router) removeComment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if !requireUser(w, ctx) {
+		return
+	}
+
+	imageID, err := strconv.ParseInt(ps.ByName("imageid"), 10, 64)
+	if err != nil {
+		sendError(w, ctx, errInvalidImageID, "")
+		return
+	}
+	commentID, err := strconv.ParseInt(ps.ByName("commentid"), 10, 64)
+	if err != nil {
+		sendError(w, ctx, errInvalidCommentID, "")
+		return
+	}
+
+	image, err := rt.db.GetImage(imageID, ctx.UserID)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
+		return
+	}
+	comment, err := rt.db.GetComment(commentID)
+	if errors.Is(err, database.ErrCommentNotFound) || (err == nil && comment.ImageID != imageID) {
+		sendError(w, ctx, errCommentNotFound, "")
+		return
+	} else if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to remove comment from the image")
+		return
+	}
+
+	if ctx.UserID != comment.UserID && ctx.UserID != image.UserID {
+		sendError(w, ctx, errForbidden, "")
+		return
+	}
+
+	if err := rt.db.RemoveComment(commentID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to remove comment from the image")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// getComments returns a page of the comments of the image, oldest first
+func (rt *_router) getComments(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	imageID, err := strconv.ParseInt(ps.ByName("imageid"), 10, 64)
+	if err != nil {
+		sendError(w, ctx, errInvalidImageID, "")
+		return
+	}
+	page, err := pageParams(r)
+	if err != nil {
+		sendError(w, ctx, errInvalidPage, err.Error())
+		return
+	}
+	if _, err := rt.db.GetImage(imageID, ctx.UserID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
+		return
+	}
+
+	comments, next, err := rt.db.GetComments(imageID, ctx.UserID, page)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve comments")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(pageResponse{Items: comments, NextCursor: next}); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
+		return
+	}
+}
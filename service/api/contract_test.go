@@ -0,0 +1,745 @@
+package api
+
+import (
+	"bytes"
+	"clean/service/blobstore"
+	"clean/service/database"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// contractClient sends requests to the API and checks that each response is declared by the OpenAPI document, with a
+// body matching the declared schema
+type contractClient struct {
+	t       *testing.T
+	spec    *openAPI
+	server  *httptest.Server
+	client  *http.Client
+	blobs   blobstore.Store
+	covered map[string]bool
+}
+
+// call is a request to an operation of the OpenAPI document
+type call struct {
+	// op is the operationId
+	op string
+
+	// path has the values of the path parameters
+	path map[string]string
+
+	query url.Values
+	token string
+
+	// body is sent as JSON, unless it's a []byte (sent as is, with contentType)
+	body        interface{}
+	contentType string
+	header      http.Header
+
+	// status is the expected status code
+	status int
+}
+
+// newContractClient starts the API on an empty in-memory database. The operations called are recorded in `covered`.
+func newContractClient(t *testing.T, covered map[string]bool) *contractClient {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	// Each connection to a plain ":memory:" database opens a different database: share the cache between connections
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	dbconn, err := sql.Open("sqlite3", "file:"+name+"?mode=memory&cache=shared&_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = dbconn.Close() })
+
+	db, err := database.New(dbconn, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blobstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := New(Config{Logger: logger, Database: db, Blobs: blobs, RenameGracePeriod: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router.Handler())
+	t.Cleanup(server.Close)
+
+	return &contractClient{
+		t:      t,
+		spec:   loadOpenAPI(t, specFile),
+		server: server,
+		client: &http.Client{
+			// Redirects are responses of the API, too
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		blobs:   blobs,
+		covered: covered,
+	}
+}
+
+// do sends the request, checks the response against the document and returns the response and its body. JSON bodies
+// are decoded into `out`, if not nil.
+func (c *contractClient) do(req call, out interface{}) (*http.Response, []byte) {
+	t := c.t
+	t.Helper()
+
+	op, ok := c.spec.operations[req.op]
+	if !ok {
+		t.Fatalf("%s: no such operation in the OpenAPI document", req.op)
+	}
+	c.covered[req.op] = true
+
+	path := op.path
+	for name, value := range req.path {
+		path = strings.ReplaceAll(path, "{"+name+"}", url.PathEscape(value))
+	}
+	if strings.Contains(path, "{") {
+		t.Fatalf("%s: missing path parameters in %s", req.op, path)
+	}
+	target := c.server.URL + path
+	if req.query != nil {
+		target += "?" + req.query.Encode()
+	}
+
+	var body []byte
+	contentType := req.contentType
+	switch b := req.body.(type) {
+	case nil:
+	case []byte:
+		body = b
+	default:
+		var err error
+		if body, err = json.Marshal(b); err != nil {
+			t.Fatal(err)
+		}
+		contentType = "application/json"
+
+		// Requests expected to succeed must be documented, too
+		if req.status < 300 {
+			schema := asMap(asMap(asMap(op.node["requestBody"])["content"])[contentType])["schema"]
+			if schema == nil {
+				t.Errorf("%s: no JSON request body in the OpenAPI document", req.op)
+			}
+			var decoded interface{}
+			_ = decodeJSONNumbers(body, &decoded)
+			for _, e := range c.spec.validate(schema, decoded, "request") {
+				t.Errorf("%s: %s", req.op, e)
+			}
+		}
+	}
+
+	r, err := http.NewRequest(op.method, target, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range req.header {
+		r.Header[k] = v
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if req.token != "" {
+		r.Header.Set("Authorization", "Bearer "+req.token)
+	}
+
+	resp, err := c.client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != req.status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", op.method, path, req.status, resp.StatusCode, respBody)
+	}
+	c.checkResponse(op, resp, respBody)
+
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			t.Fatalf("%s: decoding the response: %v", req.op, err)
+		}
+	}
+	return resp, respBody
+}
+
+// checkResponse checks that the response is declared by the operation, and that the body matches its schema
+func (c *contractClient) checkResponse(op specOperation, resp *http.Response, body []byte) {
+	t := c.t
+	t.Helper()
+
+	declared := c.spec.response(op, resp.StatusCode)
+	if declared == nil {
+		t.Errorf("%s: status %d is not documented: %s", op.id, resp.StatusCode, body)
+		return
+	}
+	content := asMap(declared["content"])
+	if content == nil {
+		// Redirects and partial contents have bodies from net/http, other responses must have none
+		if resp.StatusCode < 300 && resp.StatusCode != http.StatusPartialContent && len(body) > 0 {
+			t.Errorf("%s: status %d has no documented content, got %q", op.id, resp.StatusCode, body)
+		}
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	media, ok := content[mediaType]
+	if !ok {
+		t.Errorf("%s: status %d: content type %q is not documented", op.id, resp.StatusCode, mediaType)
+		return
+	}
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return
+	}
+
+	var decoded interface{}
+	if err := decodeJSONNumbers(body, &decoded); err != nil {
+		t.Errorf("%s: status %d: invalid JSON body: %v", op.id, resp.StatusCode, err)
+		return
+	}
+	for _, e := range c.spec.validate(asMap(media)["schema"], decoded, "response") {
+		t.Errorf("%s: status %d: %s", op.id, resp.StatusCode, e)
+	}
+}
+
+// login creates the user (if needed) and returns its session token
+func (c *contractClient) login(username string, status int) string {
+	c.t.Helper()
+	var session struct{ Token string }
+	c.do(call{op: "doLogin", body: map[string]string{"username": username}, status: status}, &session)
+	return session.Token
+}
+
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// testPNG returns a PNG image of the given size
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testGIF returns a small GIF image
+func testGIF(t *testing.T) []byte {
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.White, color.Black})
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testJPEG returns a JPEG image of the given size, with EXIF data as written by phones: the orientation, the camera
+// model ("Pixel 7") and the capture time (2023-05-01T10:20:30+02:00)
+func testJPEG(t *testing.T, width, height int, orientation uint16) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// TIFF header, IFD0 at 8 (Model, Orientation, Exif IFD at 50), Exif IFD (DateTimeOriginal, OffsetTimeOriginal),
+	// then the strings
+	var tiff bytes.Buffer
+	write := func(values ...interface{}) {
+		for _, v := range values {
+			_ = binary.Write(&tiff, binary.LittleEndian, v)
+		}
+	}
+	tiff.WriteString("II*\x00\x08\x00\x00\x00")
+	write(uint16(3), uint16(0x0110), uint16(2), uint32(8), uint32(80), uint16(0x0112), uint16(3), uint32(1),
+		uint32(orientation), uint16(0x8769), uint16(4), uint32(1), uint32(50), uint32(0))
+	write(uint16(2), uint16(0x9003), uint16(2), uint32(20), uint32(88), uint16(0x9011), uint16(2), uint32(7),
+		uint32(108), uint32(0))
+	tiff.WriteString("Pixel 7\x002023:05:01 10:20:30\x00+02:00\x00")
+
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := append([]byte{0xFF, 0xE1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}, app1...)
+	data := buf.Bytes()
+	return append(append(data[:2:2], segment...), data[2:]...)
+}
+
+// TestContract exercises every operation of doc/api.yaml, checking that status codes and bodies of the responses are
+// the documented ones. Each area has its own subtest, starting from an empty database.
+func TestContract(t *testing.T) {
+	covered := make(map[string]bool)
+	user := func(name string) map[string]string { return map[string]string{"username": name} }
+	userPath := func(ref string) map[string]string { return map[string]string{"user": ref} }
+	photo := func(id int64) map[string]string { return map[string]string{"imageid": strconv.FormatInt(id, 10)} }
+	type mention struct {
+		Username       string
+		Offset, Length int
+	}
+
+	t.Run("auth", func(t *testing.T) {
+		c := newContractClient(t, covered)
+		alice := c.login("alice", http.StatusCreated)
+		alice = c.login("alice", http.StatusOK)
+		bob := c.login("bob", http.StatusCreated)
+		c.do(call{op: "doLogin", body: user(""), status: http.StatusBadRequest}, nil)
+		c.do(call{op: "doLogin", body: user("dave smith"), status: http.StatusBadRequest}, nil)
+		c.do(call{op: "doLogin", body: map[string]string{"username": "dave", "password": "x"}, status: http.StatusBadRequest}, nil)
+
+		c.do(call{op: "setMyUserName", path: userPath("alice"), token: bob, body: user("bobby"), status: http.StatusForbidden}, nil)
+		c.do(call{op: "setMyUserName", path: userPath("alice"), body: user("bobby"), status: http.StatusUnauthorized}, nil)
+		c.do(call{op: "uploadImage", body: map[string]string{"imageurl": "https://example.com/a.png"}, status: http.StatusUnauthorized}, nil)
+
+		c.do(call{op: "getMyStream", path: userPath("bob"), token: bob, status: http.StatusOK}, nil)
+		c.do(call{op: "getMyStream", path: userPath("bob"), token: alice, status: http.StatusForbidden}, nil)
+		c.do(call{op: "getMyStream", path: userPath("bob"), status: http.StatusUnauthorized}, nil)
+		c.do(call{op: "getMyStream", path: userPath("bob"), token: "not-a-token", status: http.StatusUnauthorized}, nil)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		c := newContractClient(t, covered)
+		alice := c.login("alice", http.StatusCreated)
+		bob := c.login("bob", http.StatusCreated)
+		var session struct{ ID, Token string }
+		c.do(call{op: "doLogin", body: user("carol"), status: http.StatusCreated}, &session)
+		carol, carolID := session.Token, session.ID
+
+		c.do(call{op: "setMyUserName", path: userPath("carol"), token: carol, body: user("carla"), status: http.StatusOK}, nil)
+		c.do(call{op: "setMyUserName", path: userPath("bob"), token: bob, body: user("b"), status: http.StatusBadRequest}, nil)
+		c.do(call{op: "setMyUserName", path: userPath("bob"), token: bob, body: user("bob!"), status: http.StatusBadRequest}, nil)
+		c.do(call{op: "setMyUserName", path: userPath("bob"), token: bob, body: user("alice"), status: http.StatusConflict}, nil)
+		c.do(call{op: "setMyUserName", path: userPath("bob"), token: bob, body: user("carol"), status: http.StatusConflict}, nil)
+		c.do(call{op: "doLogin", body: user("carol"), status: http.StatusConflict}, nil)
+
+		// The ID doesn't change with the username, and it can be used in paths in place of the username
+		var profile struct{ ID, Username string }
+		c.do(call{op: "getUserProfile", path: userPath("carla"), token: alice, status: http.StatusOK}, &profile)
+		if profile.ID != carolID {
+			t.Errorf("expected the ID to survive the rename, got %q and %q", carolID, profile.ID)
+		}
+		c.do(call{op: "getUserProfile", path: userPath(carolID), token: alice, status: http.StatusOK}, &profile)
+		if profile.Username != "carla" {
+			t.Errorf("expected the ID to resolve to carla, got %q", profile.Username)
+		}
+		c.do(call{op: "getUserProfile", path: userPath("00000000-0000-4000-8000-000000000000"), token: alice, status: http.StatusNotFound}, nil)
+		c.do(call{op: "setMyUserName", path: userPath(carolID), token: carol, body: user("carla"), status: http.StatusOK}, nil)
+		resp, _ := c.do(call{op: "getUserProfile", path: userPath("carol"), token: alice, status: http.StatusTemporaryRedirect}, nil)
+		if location := resp.Header.Get("Location"); location != "/users/carla" {
+			t.Errorf("expected the old username to redirect to /users/carla, got %q", location)
+		}
+		c.do(call{op: "getUserProfile", path: userPath("nobody"), token: alice, status: http.StatusNotFound}, nil)
+		c.do(call{op: "getUserProfile", path: userPath("al"), token: alice, status: http.StatusBadRequest}, nil)
+
+		// Mentions of existing users are located in UTF-16 code units, and follow renames
+		var posted struct{ ImageID int64 }
+		c.do(call{op: "uploadImage", token: alice, body: map[string]string{"imageurl": "https://example.com/a.png"}, status: http.StatusCreated}, &posted)
+		var mentioned struct {
+			Caption  string
+			Comment  string
+			Mentions []mention
+		}
+		caption := map[string]string{"caption": "🌅 with @bob and @nobody, cc alice@example.com"}
+		c.do(call{op: "setCaption", path: photo(posted.ImageID), token: alice, body: caption, status: http.StatusOK}, &mentioned)
+		if want := []mention{{"bob", 8, 4}}; !reflect.DeepEqual(mentioned.Mentions, want) {
+			t.Errorf("expected the mentions %+v, got %+v", want, mentioned.Mentions)
+		}
+		c.do(call{op: "addComment", path: photo(posted.ImageID), token: carol, body: map[string]string{"comment": "@ALICE @bob!"}, status: http.StatusCreated}, nil)
+
+		c.do(call{op: "setMyUserName", path: userPath("bob"), token: bob, body: user("robert"), status: http.StatusOK}, nil)
+		c.do(call{op: "getImageInfo", path: photo(posted.ImageID), status: http.StatusOK}, &mentioned)
+		if want := "🌅 with @robert and @nobody, cc alice@example.com"; mentioned.Caption != want {
+			t.Errorf("expected the caption to be rewritten to %q, got %q", want, mentioned.Caption)
+		}
+		if want := []mention{{"robert", 8, 7}}; !reflect.DeepEqual(mentioned.Mentions, want) {
+			t.Errorf("expected the mentions %+v after the rename, got %+v", want, mentioned.Mentions)
+		}
+		var thread struct {
+			Items []struct {
+				Comment  string
+				Mentions []mention
+			}
+		}
+		c.do(call{op: "getComments", path: photo(posted.ImageID), status: http.StatusOK}, &thread)
+		if len(thread.Items) != 1 || thread.Items[0].Comment != "@ALICE @robert!" ||
+			!reflect.DeepEqual(thread.Items[0].Mentions, []mention{{"alice", 0, 6}, {"robert", 7, 7}}) {
+			t.Errorf("expected the comment to be rewritten, got %+v", thread.Items)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		c := newContractClient(t, covered)
+		alice := c.login("alice", http.StatusCreated)
+		bob := c.login("bob", http.StatusCreated)
+		c.login("carol", http.StatusCreated)
+
+		var users struct{ Items []struct{ Username string } }
+		c.do(call{op: "searchUsers", query: url.Values{"q": {"car"}}, token: alice, status: http.StatusOK}, &users)
+		if len(users.Items) != 1 || users.Items[0].Username != "carol" {
+			t.Errorf("expected carol, got %+v", users.Items)
+		}
+		c.do(call{op: "searchUsers", token: alice, status: http.StatusBadRequest}, nil)
+
+		// Captions and hashtags
+		var posted struct{ ImageID int64 }
+		c.do(call{op: "uploadImage", token: alice, body: map[string]string{"imageurl": "https://example.com/b.png", "caption": "Sunset at the #Beach #sea"}, status: http.StatusCreated}, &posted)
+		captioned := posted.ImageID
+		c.do(call{op: "uploadImage", token: bob, query: url.Values{"caption": {"#beach day"}}, body: testPNG(t, 5, 5), contentType: "image/png", status: http.StatusCreated}, nil)
+		c.do(call{op: "uploadImage", token: alice, body: map[string]string{"imageurl": "https://example.com/c.png", "caption": strings.Repeat("a", 2201)}, status: http.StatusBadRequest}, nil)
+
+		var tagged struct{ Items []struct{ ID int64 } }
+		c.do(call{op: "getHashtagPhotos", path: map[string]string{"tag": "BEACH"}, token: alice, status: http.StatusOK}, &tagged)
+		if len(tagged.Items) != 2 {
+			t.Errorf("expected 2 photos with #beach, got %+v", tagged.Items)
+		}
+		c.do(call{op: "getHashtagPhotos", path: map[string]string{"tag": "2023"}, status: http.StatusBadRequest}, nil)
+
+		var tags struct {
+			Items []struct {
+				Tag    string
+				Photos int
+			}
+		}
+		c.do(call{op: "searchHashtags", query: url.Values{"prefix": {"#Be"}}, status: http.StatusOK}, &tags)
+		if len(tags.Items) != 1 || tags.Items[0].Tag != "beach" || tags.Items[0].Photos != 2 {
+			t.Errorf("expected #beach with 2 photos, got %+v", tags.Items)
+		}
+		c.do(call{op: "searchHashtags", query: url.Values{"limit": {"1"}}, status: http.StatusOK}, nil)
+		c.do(call{op: "searchHashtags", query: url.Values{"prefix": {"a-b"}}, status: http.StatusBadRequest}, nil)
+
+		caption := map[string]string{"caption": "Sunset at the #ocean"}
+		c.do(call{op: "setCaption", path: photo(captioned), token: bob, body: caption, status: http.StatusForbidden}, nil)
+		c.do(call{op: "setCaption", path: photo(captioned), body: caption, status: http.StatusUnauthorized}, nil)
+		c.do(call{op: "setCaption", path: photo(999), token: alice, body: caption, status: http.StatusNotFound}, nil)
+		var edited struct{ Caption string }
+		c.do(call{op: "setCaption", path: photo(captioned), token: alice, body: caption, status: http.StatusOK}, &edited)
+		if edited.Caption != caption["caption"] {
+			t.Errorf("expected the new caption, got %q", edited.Caption)
+		}
+		c.do(call{op: "getHashtagPhotos", path: map[string]string{"tag": "sea"}, status: http.StatusOK}, &tagged)
+		if len(tagged.Items) != 0 {
+			t.Errorf("expected no photos with #sea after the edit, got %+v", tagged.Items)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		c := newContractClient(t, covered)
+		alice := c.login("alice", http.StatusCreated)
+		bob := c.login("bob", http.StatusCreated)
+		carol := c.login("carol", http.StatusCreated)
+
+		c.do(call{op: "followUser", path: userPath("bob"), token: bob, body: user("alice"), status: http.StatusOK}, nil)
+		c.do(call{op: "followUser", path: userPath("bob"), token: bob, body: user("nobody"), status: http.StatusNotFound}, nil)
+		c.do(call{op: "followUser", path: userPath("alice"), token: bob, body: user("carol"), status: http.StatusForbidden}, nil)
+		c.do(call{op: "followUser", path: userPath("carol"), token: carol, body: user("alice"), status: http.StatusOK}, nil)
+		c.do(call{op: "followUser", path: userPath("bob"), token: bob, body: user("bob"), status: http.StatusBadRequest}, nil)
+
+		var followers pageResponse
+		c.do(call{op: "getFollowers", path: userPath("alice"), token: bob, status: http.StatusOK}, &followers)
+		if items, _ := followers.Items.([]interface{}); len(items) != 2 {
+			t.Errorf("expected 2 followers, got %v", followers.Items)
+		}
+		c.do(call{op: "getFollowers", path: userPath("alice"), query: url.Values{"limit": {"1"}}, token: bob, status: http.StatusOK}, &followers)
+		if items, _ := followers.Items.([]interface{}); len(items) != 1 || followers.NextCursor == "" {
+			t.Errorf("expected a first page of 1 follower, got %+v", followers)
+		}
+		c.do(call{op: "getFollowers", path: userPath("alice"), query: url.Values{"limit": {"0"}}, token: bob, status: http.StatusBadRequest}, nil)
+		c.do(call{op: "getFollowing", path: userPath("bob"), token: alice, status: http.StatusOK}, nil)
+		c.do(call{op: "getFollowing", path: userPath("nobody"), token: alice, status: http.StatusNotFound}, nil)
+		c.do(call{op: "unfollowUser", path: userPath("carol"), token: carol, body: user("alice"), status: http.StatusOK}, nil)
+
+		// Pages are ordered from the newest photo, and the last one has no cursor
+		var ids []int64
+		for i := 0; i < 3; i++ {
+			var posted struct{ ImageID int64 }
+			c.do(call{op: "uploadImage", token: alice, body: map[string]string{"imageurl": "https://example.com/a.png"}, status: http.StatusCreated}, &posted)
+			ids = append([]int64{posted.ImageID}, ids...)
+		}
+		var page struct {
+			Items      []struct{ ID int64 }
+			NextCursor string
+		}
+		for _, op := range []call{
+			{op: "getMyStream", path: userPath("bob"), token: bob},
+			{op: "getMyPhotos", path: userPath("alice"), token: bob},
+		} {
+			var got []int64
+			query := url.Values{"limit": {"2"}}
+			for pages := 0; pages < 2; pages++ {
+				op.query, op.status = query, http.StatusOK
+				page.NextCursor = ""
+				c.do(op, &page)
+				for _, item := range page.Items {
+					got = append(got, item.ID)
+				}
+				query = url.Values{"limit": {"2"}, "cursor": {page.NextCursor}}
+			}
+			if !reflect.DeepEqual(got, ids) || page.NextCursor != "" {
+				t.Errorf("%s: expected the photos %v on two pages, got %v (next cursor %q)", op.op, ids, got, page.NextCursor)
+			}
+		}
+		c.do(call{op: "getMyStream", path: userPath("bob"), query: url.Values{"cursor": {"nope"}}, token: bob, status: http.StatusBadRequest}, nil)
+		c.do(call{op: "getMyPhotos", path: userPath("nobody"), token: bob, status: http.StatusNotFound}, nil)
+
+		c.do(call{op: "getHashtagPhotos", path: map[string]string{"tag": "beach"}, status: http.StatusOK}, nil)
+		c.do(call{op: "uploadImage", token: alice, body: map[string]string{"imageurl": "https://example.com/b.png", "caption": "#beach"}, status: http.StatusCreated}, nil)
+		c.do(call{op: "uploadImage", token: bob, body: map[string]string{"imageurl": "https://example.com/c.png", "caption": "#beach"}, status: http.StatusCreated}, nil)
+		page.NextCursor = ""
+		c.do(call{op: "getHashtagPhotos", path: map[string]string{"tag": "beach"}, query: url.Values{"limit": {"1"}}, status: http.StatusOK}, &page)
+		if len(page.Items) != 1 || page.NextCursor == "" {
+			t.Errorf("expected a first page of 1 photo, got %+v", page)
+		}
+	})
+
+	t.Run("uploads", func(t *testing.T) {
+		c := newContractClient(t, covered)
+		alice := c.login("alice", http.StatusCreated)
+		bob := c.login("bob", http.StatusCreated)
+
+		var posted struct{ ImageID int64 }
+		c.do(call{op: "uploadImage", token: alice, body: map[string]string{"imageurl": "https://example.com/a.png"}, status: http.StatusCreated}, &posted)
+		byURL := posted.ImageID
+		c.do(call{op: "uploadImage", token: alice, body: testPNG(t, 4, 4), contentType: "image/png", status: http.StatusCreated}, &posted)
+		uploaded := posted.ImageID
+		c.do(call{op: "uploadImage", token: alice, body: testPNG(t, 200, 100), contentType: "image/png", status: http.StatusCreated}, &posted)
+		wide := posted.ImageID
+		c.do(call{op: "uploadImage", token: alice, body: testGIF(t), contentType: "image/gif", status: http.StatusCreated}, nil)
+		c.do(call{op: "uploadImage", token: alice, body: append([]byte("\x89PNG\r\n\x1a\n"), "broken"...), contentType: "image/png", status: http.StatusBadRequest}, nil)
+		c.do(call{op: "uploadImage", token: alice, body: []byte("hello"), contentType: "text/plain", status: http.StatusUnsupportedMediaType}, nil)
+		c.do(call{op: "uploadImage", token: bob, body: map[string]string{"imageurl": "https://example.com/a.png"}, status: http.StatusCreated}, nil)
+		c.do(call{op: "uploadImage", token: alice, body: map[string]string{"imageurl": "a.png"}, status: http.StatusBadRequest}, nil)
+		for _, invalid := range []string{"javascript:alert(1)", "//example.com/a.png", "/images/1/raw", "ftp://example.com/a.png", "https:///a.png"} {
+			c.do(call{op: "uploadImage", token: alice, body: map[string]string{"imageurl": invalid}, status: http.StatusBadRequest}, nil)
+		}
+
+		c.do(call{op: "getImageInfo", path: photo(uploaded), token: bob, status: http.StatusOK}, nil)
+		c.do(call{op: "getImageInfo", path: photo(999), token: bob, status: http.StatusNotFound}, nil)
+
+		// Only the variants narrower than the photo exist
+		var info struct {
+			Variants map[string]struct {
+				Width, Height int
+				URL           string
+			}
+		}
+		c.do(call{op: "getImageInfo", path: photo(wide), token: bob, status: http.StatusOK}, &info)
+		if v, ok := info.Variants["150"]; len(info.Variants) != 1 || !ok || v.Height != 75 || !strings.HasSuffix(v.URL, "/raw?size=150") {
+			t.Errorf("expected a single 150x75 variant, got %+v", info.Variants)
+		}
+		resp, _ := c.do(call{op: "getImageRaw", path: photo(wide), query: url.Values{"size": {"150"}}, status: http.StatusOK}, nil)
+		variantETag := resp.Header.Get("ETag")
+		resp, _ = c.do(call{op: "getImageRaw", path: photo(wide), query: url.Values{"size": {"640"}}, status: http.StatusOK}, nil)
+		if resp.Header.Get("ETag") == variantETag {
+			t.Error("expected the photo itself when it's narrower than the requested size")
+		}
+		c.do(call{op: "getImageRaw", path: photo(wide), query: url.Values{"size": {"151"}}, status: http.StatusBadRequest}, nil)
+
+		// Photos are stored upright and without metadata. The capture time and the camera model are published on demand.
+		keep := url.Values{"keepMetadata": {"true"}}
+		c.do(call{op: "uploadImage", token: alice, query: keep, body: testJPEG(t, 200, 400, 6), contentType: "image/jpeg", status: http.StatusCreated}, &posted)
+		rotated := posted.ImageID
+		c.do(call{op: "uploadImage", token: alice, body: testJPEG(t, 200, 400, 1), contentType: "image/jpeg", status: http.StatusCreated}, &posted)
+		upright := posted.ImageID
+		c.do(call{op: "uploadImage", token: alice, query: url.Values{"keepMetadata": {"maybe"}}, body: testJPEG(t, 4, 4, 1), contentType: "image/jpeg", status: http.StatusBadRequest}, nil)
+
+		var metadata struct{ TakenAt, CameraModel string }
+		c.do(call{op: "getImageInfo", path: photo(rotated), token: bob, status: http.StatusOK}, &metadata)
+		if metadata.TakenAt != "2023-05-01T10:20:30+02:00" || metadata.CameraModel != "Pixel 7" {
+			t.Errorf("expected the capture time and the camera model, got %+v", metadata)
+		}
+		metadata.TakenAt, metadata.CameraModel = "", ""
+		c.do(call{op: "getImageInfo", path: photo(upright), token: bob, status: http.StatusOK}, &metadata)
+		if metadata.TakenAt != "" || metadata.CameraModel != "" {
+			t.Errorf("expected no metadata without keepMetadata, got %+v", metadata)
+		}
+		for id, size := range map[int64]image.Point{rotated: {400, 200}, upright: {200, 400}} {
+			_, raw := c.do(call{op: "getImageRaw", path: photo(id), status: http.StatusOK}, nil)
+			config, err := jpeg.DecodeConfig(bytes.NewReader(raw))
+			if err != nil || config.Width != size.X || config.Height != size.Y {
+				t.Errorf("expected a %v photo, got %+v (%v)", size, config, err)
+			}
+			if bytes.Contains(raw, []byte("Exif")) || bytes.Contains(raw, []byte("Pixel 7")) {
+				t.Errorf("expected the metadata of photo %d to be stripped", id)
+			}
+		}
+
+		resp, _ = c.do(call{op: "getImageRaw", path: photo(uploaded), token: bob, status: http.StatusOK}, nil)
+		etag := resp.Header.Get("ETag")
+		c.do(call{op: "getImageRaw", path: photo(uploaded), header: http.Header{"If-None-Match": {etag}}, status: http.StatusNotModified}, nil)
+		c.do(call{op: "getImageRaw", path: photo(uploaded), header: http.Header{"Range": {"bytes=0-3"}}, status: http.StatusPartialContent}, nil)
+		c.do(call{op: "getImageRaw", path: photo(byURL), status: http.StatusFound}, nil)
+		c.do(call{op: "getImageRaw", path: photo(999), status: http.StatusNotFound}, nil)
+
+		// Identical photos share their blob, which is removed with the last of them
+		shared := testPNG(t, 300, 100)
+		c.do(call{op: "uploadImage", token: alice, body: shared, contentType: "image/png", status: http.StatusCreated}, &posted)
+		sharedByAlice := posted.ImageID
+		c.do(call{op: "uploadImage", token: bob, body: shared, contentType: "image/png", status: http.StatusCreated}, &posted)
+		sharedByBob := posted.ImageID
+		resp, _ = c.do(call{op: "getImageRaw", path: photo(sharedByAlice), status: http.StatusOK}, nil)
+		sharedETag := resp.Header.Get("ETag")
+		resp, _ = c.do(call{op: "getImageRaw", path: photo(sharedByBob), status: http.StatusOK}, nil)
+		if resp.Header.Get("ETag") != sharedETag {
+			t.Errorf("expected identical photos to share the blob, got %s and %s", sharedETag, resp.Header.Get("ETag"))
+		}
+		c.do(call{op: "deletePhoto", path: photo(sharedByAlice), token: alice, status: http.StatusOK}, nil)
+		c.do(call{op: "getImageRaw", path: photo(sharedByBob), query: url.Values{"size": {"150"}}, status: http.StatusOK}, nil)
+		c.do(call{op: "getImageRaw", path: photo(sharedByBob), status: http.StatusOK}, nil)
+		c.do(call{op: "deletePhoto", path: photo(sharedByBob), token: bob, status: http.StatusOK}, nil)
+		if _, err := c.blobs.Open(strings.Trim(sharedETag, `"`)); err != blobstore.ErrBlobNotFound {
+			t.Errorf("expected the blob to be removed with the last photo, got %v", err)
+		}
+
+		c.do(call{op: "deletePhoto", path: photo(uploaded), token: bob, status: http.StatusForbidden}, nil)
+		c.do(call{op: "deletePhoto", path: photo(uploaded), token: alice, status: http.StatusOK}, nil)
+		c.do(call{op: "deletePhoto", path: photo(uploaded), token: alice, status: http.StatusNotFound}, nil)
+	})
+
+	t.Run("likes", func(t *testing.T) {
+		c := newContractClient(t, covered)
+		alice := c.login("alice", http.StatusCreated)
+		bob := c.login("bob", http.StatusCreated)
+		var posted struct{ ImageID int64 }
+		c.do(call{op: "uploadImage", token: alice, body: testPNG(t, 4, 4), contentType: "image/png", status: http.StatusCreated}, &posted)
+
+		like := map[string]string{"imageid": strconv.FormatInt(posted.ImageID, 10), "user": "bob"}
+		c.do(call{op: "likePhoto", path: like, token: bob, status: http.StatusOK}, nil)
+		c.do(call{op: "likePhoto", path: like, token: alice, status: http.StatusForbidden}, nil)
+		c.do(call{op: "likePhoto", path: like, status: http.StatusUnauthorized}, nil)
+		var likers struct{ Items []string }
+		c.do(call{op: "getLikes", path: photo(posted.ImageID), token: alice, status: http.StatusOK}, &likers)
+		if !reflect.DeepEqual(likers.Items, []string{"bob"}) {
+			t.Errorf("expected the like of bob, got %v", likers.Items)
+		}
+		c.do(call{op: "getLikes", path: photo(999), token: alice, status: http.StatusNotFound}, nil)
+		c.do(call{op: "unlikePhoto", path: like, token: bob, status: http.StatusOK}, nil)
+	})
+
+	t.Run("comments", func(t *testing.T) {
+		c := newContractClient(t, covered)
+		alice := c.login("alice", http.StatusCreated)
+		bob := c.login("bob", http.StatusCreated)
+		carol := c.login("carol", http.StatusCreated)
+		var posted struct{ ImageID int64 }
+		c.do(call{op: "uploadImage", token: alice, body: testPNG(t, 4, 4), contentType: "image/png", status: http.StatusCreated}, &posted)
+		uploaded := posted.ImageID
+
+		var comment struct {
+			CommentID int64
+			Mentions  []mention
+		}
+		c.do(call{op: "addComment", path: photo(uploaded), token: bob, body: map[string]string{"comment": "nice"}, status: http.StatusCreated}, &comment)
+		c.do(call{op: "addComment", path: photo(uploaded), token: bob, body: map[string]string{"comment": ""}, status: http.StatusBadRequest}, nil)
+		c.do(call{op: "addComment", path: photo(uploaded), body: map[string]string{"comment": "nice"}, status: http.StatusUnauthorized}, nil)
+		c.do(call{op: "getComments", path: photo(uploaded), token: alice, status: http.StatusOK}, nil)
+		c.do(call{op: "getComments", path: photo(999), token: alice, status: http.StatusNotFound}, nil)
+
+		commentPath := map[string]string{"imageid": strconv.FormatInt(uploaded, 10), "commentid": strconv.FormatInt(comment.CommentID, 10)}
+		c.do(call{op: "removeComment", path: commentPath, token: carol, status: http.StatusForbidden}, nil)
+		c.do(call{op: "removeComment", path: commentPath, token: bob, status: http.StatusOK}, nil)
+		c.do(call{op: "removeComment", path: commentPath, token: bob, status: http.StatusNotFound}, nil)
+
+		// Mentions are case insensitive, and end at the first character not allowed in usernames
+		c.do(call{op: "addComment", path: photo(uploaded), token: carol, body: map[string]string{"comment": "@ALICE @bob!"}, status: http.StatusCreated}, &comment)
+		if want := []mention{{"alice", 0, 6}, {"bob", 7, 4}}; !reflect.DeepEqual(comment.Mentions, want) {
+			t.Errorf("expected the mentions %+v, got %+v", want, comment.Mentions)
+		}
+	})
+
+	t.Run("bans", func(t *testing.T) {
+		c := newContractClient(t, covered)
+		alice := c.login("alice", http.StatusCreated)
+		bob := c.login("bob", http.StatusCreated)
+		carol := c.login("carol", http.StatusCreated)
+		var posted struct{ ImageID int64 }
+		c.do(call{op: "uploadImage", token: alice, body: testPNG(t, 4, 4), contentType: "image/png", status: http.StatusCreated}, &posted)
+		uploaded := posted.ImageID
+		c.do(call{op: "uploadImage", token: alice, body: map[string]string{"imageurl": "https://example.com/b.png", "caption": "#beach"}, status: http.StatusCreated}, &posted)
+		captioned := posted.ImageID
+		c.do(call{op: "uploadImage", token: bob, body: map[string]string{"imageurl": "https://example.com/c.png", "caption": "#beach"}, status: http.StatusCreated}, nil)
+
+		// Photos of users banned by the viewer are left out of hashtags
+		c.do(call{op: "banUser", path: userPath("carol"), token: carol, body: user("alice"), status: http.StatusOK}, nil)
+		var tagged struct{ Items []struct{ ID int64 } }
+		c.do(call{op: "getHashtagPhotos", path: map[string]string{"tag": "beach"}, token: carol, status: http.StatusOK}, &tagged)
+		if len(tagged.Items) != 1 || tagged.Items[0].ID == captioned {
+			t.Errorf("expected the photo of the banned user to be left out, got %+v", tagged.Items)
+		}
+		var tags struct{ Items []struct{ Photos int } }
+		c.do(call{op: "searchHashtags", query: url.Values{"prefix": {"beach"}}, token: carol, status: http.StatusOK}, &tags)
+		if len(tags.Items) != 1 || tags.Items[0].Photos != 1 {
+			t.Errorf("expected the photo of the banned user not to be counted, got %+v", tags.Items)
+		}
+		c.do(call{op: "unbanUser", path: userPath("carol"), token: carol, body: user("alice"), status: http.StatusOK}, nil)
+
+		// Likes and comments of users banned by the viewer, or who banned the viewer, are hidden to the viewer
+		c.do(call{op: "likePhoto", path: map[string]string{"imageid": strconv.FormatInt(captioned, 10), "user": "carol"}, token: carol, status: http.StatusOK}, nil)
+		c.do(call{op: "addComment", path: photo(captioned), token: carol, body: map[string]string{"comment": "nice"}, status: http.StatusCreated}, nil)
+		c.do(call{op: "banUser", path: userPath("carol"), token: carol, body: user("alice"), status: http.StatusOK}, nil)
+		var likers struct{ Items []string }
+		var thread struct{ Items []struct{ Comment string } }
+		c.do(call{op: "getLikes", path: photo(captioned), token: alice, status: http.StatusOK}, &likers)
+		if len(likers.Items) != 0 {
+			t.Errorf("expected the like of the banner to be hidden, got %v", likers.Items)
+		}
+		c.do(call{op: "getComments", path: photo(captioned), token: alice, status: http.StatusOK}, &thread)
+		if len(thread.Items) != 0 {
+			t.Errorf("expected the comment of the banner to be hidden, got %+v", thread.Items)
+		}
+		c.do(call{op: "getLikes", path: photo(captioned), token: bob, status: http.StatusOK}, &likers)
+		c.do(call{op: "getComments", path: photo(captioned), token: bob, status: http.StatusOK}, &thread)
+		if len(likers.Items) != 1 || len(thread.Items) != 1 {
+			t.Errorf("expected the like and the comment to be visible to others, got %v and %+v", likers.Items, thread.Items)
+		}
+		c.do(call{op: "unbanUser", path: userPath("carol"), token: carol, body: user("alice"), status: http.StatusOK}, nil)
+
+		// Bans hide the photos and the profile of the banner
+		c.do(call{op: "banUser", path: userPath("alice"), token: alice, body: user("bob"), status: http.StatusOK}, nil)
+		c.do(call{op: "banUser", path: userPath("alice"), token: alice, body: user("nobody"), status: http.StatusNotFound}, nil)
+		c.do(call{op: "banUser", path: userPath("alice"), token: alice, body: user("alice"), status: http.StatusBadRequest}, nil)
+		c.do(call{op: "getUserProfile", path: userPath("alice"), token: bob, status: http.StatusNotFound}, nil)
+		c.do(call{op: "getImageInfo", path: photo(uploaded), token: bob, status: http.StatusNotFound}, nil)
+		// Bans only apply to logged-in users: anonymous requests see everything
+		c.do(call{op: "getUserProfile", path: userPath("alice"), status: http.StatusOK}, nil)
+		c.do(call{op: "getImageInfo", path: photo(uploaded), status: http.StatusOK}, nil)
+		c.do(call{op: "getImageRaw", path: photo(uploaded), status: http.StatusOK}, nil)
+		c.do(call{op: "followUser", path: userPath("alice"), token: alice, body: user("bob"), status: http.StatusForbidden}, nil)
+		c.do(call{op: "unbanUser", path: userPath("alice"), token: alice, body: user("bob"), status: http.StatusOK}, nil)
+		c.do(call{op: "unbanUser", path: userPath("alice"), token: bob, body: user("bob"), status: http.StatusForbidden}, nil)
+	})
+
+	if t.Failed() {
+		return
+	}
+	for _, id := range loadOpenAPI(t, specFile).operationIDs() {
+		if !covered[id] {
+			t.Errorf("operation %s is not exercised by the contract test", id)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"clean/service/api/reqcontext"
+	"clean/service/database"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// errorKind is a kind of error replied by the API. Each kind has a stable machine-readable code, which clients can rely
+// on, and a short human-readable title.
+type errorKind struct {
+	status int
+	code   string
+	title  string
+}
+
+// Errors replied by the API. The codes are documented in doc/api.yaml (Problem schema): keep them in sync.
+var (
+	errInvalidBody          = errorKind{http.StatusBadRequest, "invalid_request_body", "Invalid request body"}
+	errValidation           = errorKind{http.StatusBadRequest, "validation_failed", "Invalid request"}
+	errInvalidImageID       = errorKind{http.StatusBadRequest, "invalid_image_id", "Invalid image ID"}
+	errInvalidCommentID     = errorKind{http.StatusBadRequest, "invalid_comment_id", "Invalid comment ID"}
+	errInvalidPage          = errorKind{http.StatusBadRequest, "invalid_page", "Invalid limit or cursor"}
+	errMissingQuery         = errorKind{http.StatusBadRequest, "missing_query", "Missing search query"}
+	errMissingImage         = errorKind{http.StatusBadRequest, "missing_image", "Missing image in form data"}
+	errInvalidImage         = errorKind{http.StatusBadRequest, "invalid_image", "Invalid image"}
+	errUnauthorized         = errorKind{http.StatusUnauthorized, "unauthorized", "Authentication required"}
+	errForbidden            = errorKind{http.StatusForbidden, "forbidden", "Forbidden"}
+	errBanned               = errorKind{http.StatusForbidden, "banned", "Banned user"}
+	errUserNotFound         = errorKind{http.StatusNotFound, "user_not_found", "User not found"}
+	errImageNotFound        = errorKind{http.StatusNotFound, "image_not_found", "Image not found"}
+	errCommentNotFound      = errorKind{http.StatusNotFound, "comment_not_found", "Comment not found"}
+	errUsernameTaken        = errorKind{http.StatusConflict, "username_taken", "Username already taken"}
+	errImageTooLarge        = errorKind{http.StatusRequestEntityTooLarge, "image_too_large", "Image too large"}
+	errRequestTooLarge      = errorKind{http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large"}
+	errUnsupportedMediaType = errorKind{http.StatusUnsupportedMediaType, "unsupported_media_type", "Unsupported media type"}
+	errInternal             = errorKind{http.StatusInternalServerError, "internal_error", "Internal server error"}
+)
+
+// problem is the body of error responses, as described by RFC 7807 (application/problem+json)
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+
+	// Code is the machine-readable code of the error kind
+	Code string `json:"code"`
+
+	// RequestID is the ID of the request, to be reported by users
+	RequestID string `json:"requestId"`
+
+	// Errors lists the values violating the constraints of the API, for validation_failed errors
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// sendError replies to the request with an error of the given kind. `detail` explains this occurrence of the error, and
+// can be empty. The body includes the request ID, so that users can report it.
+func sendError(w http.ResponseWriter, ctx reqcontext.RequestContext, kind errorKind, detail string) {
+	writeProblem(w, newProblem(ctx, kind, detail))
+}
+
+// sendValidationError replies to the request with a validation_failed error listing the violations
+func sendValidationError(w http.ResponseWriter, ctx reqcontext.RequestContext, errs []fieldError) {
+	p := newProblem(ctx, errValidation, "")
+	p.Errors = errs
+	writeProblem(w, p)
+}
+
+func newProblem(ctx reqcontext.RequestContext, kind errorKind, detail string) problem {
+	return problem{
+		Type:      "about:blank",
+		Title:     kind.title,
+		Status:    kind.status,
+		Detail:    detail,
+		Code:      kind.code,
+		RequestID: ctx.ReqID,
+	}
+}
+
+func writeProblem(w http.ResponseWriter, p problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// sendDatabaseError replies to the request with the error returned by the database. Errors that are not expected by
+// clients (e.g., I/O errors) are logged and replied as internal errors, with `detail` describing the failed operation.
+func sendDatabaseError(w http.ResponseWriter, ctx reqcontext.RequestContext, err error, detail string) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		sendError(w, ctx, errUserNotFound, "")
+	case errors.Is(err, database.ErrImageNotFound):
+		sendError(w, ctx, errImageNotFound, "")
+	case errors.Is(err, database.ErrCommentNotFound):
+		sendError(w, ctx, errCommentNotFound, "")
+	case errors.Is(err, database.ErrUsernameTaken):
+		sendError(w, ctx, errUsernameTaken, "")
+	case errors.Is(err, database.ErrBanned):
+		sendError(w, ctx, errBanned, "")
+	case errors.Is(err, database.ErrSelfRelation):
+		sendValidationError(w, ctx, []fieldError{{In: "body", Field: "username", Message: "must not be the authenticated user"}})
+	case errors.Is(err, database.ErrInvalidCursor):
+		sendError(w, ctx, errInvalidPage, "invalid cursor")
+	default:
+		ctx.Logger.WithError(err).Error(detail)
+		sendError(w, ctx, errInternal, detail)
+	}
+}
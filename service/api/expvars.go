@@ -0,0 +1,14 @@
+package api
+
+import (
+	"expvar"
+)
+
+// Variables exported in /debug/vars by the debug server (see the `expvar` package)
+var (
+	// requestsByRoute counts the requests received by each route ("METHOD /path")
+	requestsByRoute = expvar.NewMap("requests")
+
+	// uploads counts the photos uploaded successfully
+	uploads = expvar.NewInt("uploads")
+)
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"clean/service/api/reqcontext"
+	"clean/service/database"
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// getFollowers returns a page of the users following the user in the path
+func (rt *_router) getFollowers(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.listFollows(w, r, ps, ctx, rt.db.GetFollowers)
+}
+
+// getFollowing returns a page of the users followed by the user in the path
+func (rt *_router) getFollowing(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.listFollows(w, r, ps, ctx, rt.db.GetFollowing)
+}
+
+// listFollows replies with a page of the list of users returned by `list` for the user in the path
+func (rt *_router) listFollows(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext,
+	list func(userID, viewerID string, page database.Page) ([]database.UserSummary, string, error)) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, err := pageParams(r)
+	if err != nil {
+		sendError(w, ctx, errInvalidPage, err.Error())
+		return
+	}
+
+	user, ok := rt.pathUser(w, ps, ctx)
+	if !ok {
+		return
+	}
+
+	users, next, err := list(user.ID, ctx.UserID, page)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve users")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(pageResponse{Items: users, NextCursor: next}); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
+		return
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"clean/service/api/reqcontext"
+	"clean/service/entities"
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+)
+
+// getHashtagPhotos returns a page of the photos with the hashtag in their caption, newest first
+func (rt *_router) getHashtagPhotos(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// The tag has been validated with the path
+	tag, _ := entities.NormalizeHashtag(ps.ByName("tag"))
+	page, err := pageParams(r)
+	if err != nil {
+		sendError(w, ctx, errInvalidPage, err.Error())
+		return
+	}
+
+	images, next, err := rt.db.GetHashtagPhotos(tag, ctx.UserID, page)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the photos")
+		return
+	}
+	for i := range images {
+		setRawURL(&images[i])
+	}
+
+	if err := json.NewEncoder(w).Encode(pageResponse{Items: images, NextCursor: next}); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
+		return
+	}
+}
+
+// searchHashtags returns a page of the hashtags starting with the `prefix` query parameter, the most used first, for
+// autocompletion. Without prefix, all the hashtags are listed.
+func (rt *_router) searchHashtags(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	prefix := strings.TrimPrefix(r.URL.Query().Get("prefix"), "#")
+	if !entities.IsHashtagPrefix(prefix) {
+		var v validator
+		v.add("query", "prefix", fmt.Sprintf("must be up to %d letters, digits and underscores",
+			entities.MaxHashtagLength))
+		v.valid(w, ctx)
+		return
+	}
+	page, err := pageParams(r)
+	if err != nil {
+		sendError(w, ctx, errInvalidPage, err.Error())
+		return
+	}
+
+	tags, next, err := rt.db.SearchHashtags(strings.ToLower(prefix), ctx.UserID, page)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to search hashtags")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(pageResponse{Items: tags, NextCursor: next}); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
+		return
+	}
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"clean/service/api/reqcontext"
+	"clean/service/blobstore"
+	"clean/service/database"
+	"clean/service/imaging"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// maxUploadSize is the maximum size in bytes of an uploaded photo
+const maxUploadSize = 10 << 20
+
+// uploadFormField is the name of the multipart/form-data field carrying the photo
+const uploadFormField = "image"
+
+// allowedImageTypes lists the content types accepted for uploads, as detected by http.DetectContentType
+var allowedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// uploadImageBlob saves the photo in the request body, and its variants (see the imaging package), into the blob store
+// and inserts the new image in the database. The body can be either a multipart/form-data with the photo in the "image"
+// field, or the raw photo bytes.
+//
+// The caption is in the `caption` query parameter. The photo is stored upright and without metadata. With the
+// `keepMetadata` query parameter, the capture time and the camera model are published in the image record.
+func (rt *_router) uploadImageBlob(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, mediaType string) {
+	w.Header().Set("Content-Type", "application/json")
+	var v validator
+	caption := r.URL.Query().Get("caption")
+	v.caption("query", "caption", caption)
+	keepMetadata := false
+	if s := r.URL.Query().Get("keepMetadata"); s != "" {
+		var err error
+		if keepMetadata, err = strconv.ParseBool(s); err != nil {
+			v.add("query", "keepMetadata", "must be true or false")
+		}
+	}
+	if !v.valid(w, ctx) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	var body io.Reader = r.Body
+	if mediaType == "multipart/form-data" {
+		part, err := formFilePart(r, uploadFormField)
+		if err != nil {
+			ctx.Logger.WithError(err).Debug("invalid multipart upload")
+			sendError(w, ctx, errMissingImage, "")
+			return
+		}
+		defer part.Close()
+		body = part
+	}
+
+	// Check the real content type from the first bytes, instead of trusting the client
+	br := bufio.NewReaderSize(body, 512)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		uploadError(w, ctx, err)
+		return
+	}
+	if !allowedImageTypes[http.DetectContentType(head)] {
+		sendError(w, ctx, errUnsupportedMediaType, "only JPEG, PNG and GIF images are supported")
+		return
+	}
+
+	// The body is streamed into a temporary file (as ParseMultipartForm does with large files), so that the photo is
+	// held in memory only while it's processed
+	file, err := spoolUpload(br)
+	if err != nil {
+		uploadError(w, ctx, err)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	photo, err := imaging.Process(file)
+	if errors.Is(err, imaging.ErrTooLarge) {
+		sendError(w, ctx, errImageTooLarge, fmt.Sprintf("the image must have at most %d pixels", imaging.MaxPixels))
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Debug("can't decode the uploaded image")
+		sendError(w, ctx, errInvalidImage, "")
+		return
+	}
+
+	// Blobs are addressed by their content, so identical photos (and their variants) share them
+	key := blobKey(photo.Data)
+	variants := make([]database.Variant, 0, len(photo.Variants))
+	for _, v := range photo.Variants {
+		variants = append(variants, database.Variant{Width: v.Width, Height: v.Height, BlobKey: blobKey(v.Data),
+			ContentType: v.ContentType})
+	}
+
+	var metadata database.PhotoMetadata
+	if keepMetadata {
+		metadata = database.PhotoMetadata{TakenAt: photo.Metadata.TakenAt, CameraModel: photo.Metadata.CameraModel}
+	}
+	// The blobs are saved once referenced, so they can't be removed meanwhile by the deletion of another image, and
+	// before the image is committed. Saving a blob that's already stored replaces it with the same content. If saving
+	// fails, the blobs already saved are left in the store: they may be shared with other images.
+	blobs := map[string][]byte{key: photo.Data}
+	for i, v := range photo.Variants {
+		blobs[variants[i].BlobKey] = v.Data
+	}
+	var saveErr error
+	id, err := rt.db.InsertImageBlob(ctx.UserID, key, photo.ContentType, caption, metadata, variants, func() error {
+		for k, data := range blobs {
+			if _, saveErr = rt.blobs.Put(k, bytes.NewReader(data)); saveErr != nil {
+				return saveErr
+			}
+		}
+		return nil
+	})
+	if saveErr != nil {
+		uploadError(w, ctx, saveErr)
+		return
+	} else if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to insert image into the database")
+		return
+	}
+	uploads.Add(1)
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"Username": ctx.Username,
+		"imageId":  id,
+	})
+}
+
+// formFilePart returns the part of the multipart/form-data body with the given field name
+func formFilePart(r *http.Request, field string) (io.ReadCloser, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == field {
+			return part, nil
+		}
+		_ = part.Close()
+	}
+}
+
+// spoolUpload copies the uploaded photo into a new temporary file, positioned at its start. The caller must close and
+// remove the file.
+func spoolUpload(r io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(file, r)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// uploadError replies to a failed read of the uploaded photo, or to a failed save into the blob store
+func uploadError(w http.ResponseWriter, ctx reqcontext.RequestContext, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		sendError(w, ctx, errImageTooLarge, "")
+		return
+	}
+	ctx.Logger.WithError(err).Error("can't store the uploaded image")
+	sendError(w, ctx, errInternal, "Failed to store image")
+}
+
+// getImageRaw serves the bytes of an uploaded photo, or of one of its variants with the `size` query parameter (the
+// width of the variant). Photos narrower than the requested size are served as they are. Range requests and conditional
+// requests (using the ETag or the upload date) are supported. Photos posted by URL are redirected to their URL.
+func (rt *_router) getImageRaw(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	imageID, err := strconv.ParseInt(ps.ByName("imageid"), 10, 64)
+	if err != nil {
+		sendError(w, ctx, errInvalidImageID, "")
+		return
+	}
+	size := 0
+	if s := r.URL.Query().Get("size"); s != "" {
+		var v validator
+		if size, err = strconv.Atoi(s); err != nil || !imaging.IsWidth(size) {
+			v.add("query", "size", fmt.Sprintf("must be one of %v", imaging.Widths))
+		}
+		if !v.valid(w, ctx) {
+			return
+		}
+	}
+
+	image, err := rt.db.GetImage(imageID, ctx.UserID)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
+		return
+	}
+	if image.BlobKey == "" {
+		// Photos posted before the URLs were checked may have any URL
+		if !isRedirectURL(image.ImageURL) {
+			sendError(w, ctx, errImageNotFound, "")
+			return
+		}
+		http.Redirect(w, r, image.ImageURL, http.StatusFound)
+		return
+	}
+
+	blobKey, contentType := image.BlobKey, image.ContentType
+	if variant, ok := image.Variants[size]; ok {
+		blobKey, contentType = variant.BlobKey, variant.ContentType
+	}
+
+	blob, err := rt.blobs.Open(blobKey)
+	if errors.Is(err, blobstore.ErrBlobNotFound) {
+		ctx.Logger.WithField("blob", blobKey).Warning("photo blob is missing from the store")
+		sendError(w, ctx, errImageNotFound, "")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't open the photo blob")
+		sendError(w, ctx, errInternal, "Failed to read image")
+		return
+	}
+	defer blob.Close()
+
+	// Blobs never change, so their key is a strong validator
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+blobKey+`"`)
+	http.ServeContent(w, r, "", image.CreatedAt, blob)
+}
+
+// setRawURL points the URL of uploaded photos, and of their variants, to the endpoint serving their bytes
+func setRawURL(image *database.Image) {
+	if image.BlobKey != "" {
+		image.ImageURL = fmt.Sprintf("/images/%d/raw", image.ID)
+	}
+	for width, v := range image.Variants {
+		v.URL = fmt.Sprintf("/images/%d/raw?size=%d", image.ID, width)
+		image.Variants[width] = v
+	}
+}
+
+// blobKey returns the key of a blob with the given content: the hex SHA-256 of the content
+func blobKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// removeImage deletes the image from the database, and its blobs that no other image references from the store
+func (rt *_router) removeImage(ctx reqcontext.RequestContext, imageID int64) error {
+	return rt.db.RemoveImage(imageID, func(keys []string) { rt.deleteBlobs(ctx, keys...) })
+}
+
+// deleteBlobs removes the blobs from the store. Failures are only logged: a leftover blob wastes space, but it's not
+// reachable anymore.
+func (rt *_router) deleteBlobs(ctx reqcontext.RequestContext, keys ...string) {
+	for _, key := range keys {
+		if err := rt.blobs.Delete(key); err != nil {
+			ctx.Logger.WithError(err).WithField("blob", key).Warning("can't delete the photo blob")
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"clean/service/api/reqcontext"
 	"encoding/json"
 	"github.com/julienschmidt/httprouter"
+	"mime"
 	"net/http"
 	"strconv"
 )
@@ -11,42 +12,83 @@ import (
 func (rt *_router) getMyStream(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	username := ps.ByName("username")
-	if username == "" {
-		http.Error(w, "Missing username in path", http.StatusBadRequest)
+	// The stream is personal: only its owner can read it
+	if !requirePathOwner(w, ps, ctx) {
 		return
 	}
 
-	images, err := rt.db.GetStream(username)
+	page, err := pageParams(r)
 	if err != nil {
-		http.Error(w, "Failed to retrieve stream", http.StatusInternalServerError)
+		sendError(w, ctx, errInvalidPage, err.Error())
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(images); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	images, next, err := rt.db.GetStream(ctx.UserID, ctx.UserID, page)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve stream")
+		return
+	}
+	for i := range images {
+		setRawURL(&images[i])
+	}
+
+	if err := json.NewEncoder(w).Encode(pageResponse{Items: images, NextCursor: next}); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
 		return
 	}
 }
 
 func (rt *_router) uploadImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireUser(w, ctx) {
+		return
+	}
+
+	// Photos can be uploaded as binary content, or posted by URL using a JSON body
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data", "image/jpeg", "image/png", "image/gif":
+		rt.uploadImageBlob(w, r, ctx, mediaType)
+		return
+	case "", "application/json":
+	default:
+		sendError(w, ctx, errUnsupportedMediaType, "")
+		return
+	}
+
 	var requestBody struct {
 		Username string `json:"username"`
 		ImageURL string `json:"imageurl"`
+		Caption  string `json:"caption"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, ctx, &requestBody) {
 		return
 	}
-	id, err := rt.db.InsertImage(requestBody.ImageURL, requestBody.Username)
+	var v validator
+	if requestBody.Username != "" {
+		v.username("body", "username", requestBody.Username)
+	}
+	v.imageURL("body", "imageurl", requestBody.ImageURL)
+	v.caption("body", "caption", requestBody.Caption)
+	if !v.valid(w, ctx) {
+		return
+	}
+
+	// Users can only post as themselves
+	if requestBody.Username != "" && requestBody.Username != ctx.Username {
+		sendError(w, ctx, errForbidden, "")
+		return
+	}
+	id, err := rt.db.InsertImage(requestBody.ImageURL, ctx.UserID, requestBody.Caption)
 	if err != nil {
-		http.Error(w, "Failed to insert image into the database", http.StatusInternalServerError)
+		sendDatabaseError(w, ctx, err, "Failed to insert image into the database")
 		return
 	}
+	uploads.Add(1)
 
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"Username": requestBody.Username,
+		"Username": ctx.Username,
 		"imageId":  id,
 	})
 }
@@ -55,110 +97,106 @@ func (rt *_router) deletePhoto(w http.ResponseWriter, r *http.Request, ps httpro
 	w.Header().Set("Content-Type", "application/json")
 	idStr := ps.ByName("imageid")
 	if idStr == "" {
-		http.Error(w, "Missing image id in path", http.StatusBadRequest)
+		sendError(w, ctx, errInvalidImageID, "missing image ID")
 		return
 	}
 
 	imageID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid image id", http.StatusBadRequest)
+		sendError(w, ctx, errInvalidImageID, "")
 		return
 	}
 
-	if err := rt.db.RemoveImage(imageID); err != nil {
-		http.Error(w, "Failed to delete image", http.StatusInternalServerError)
+	// Only the owner can delete the photo
+	image, err := rt.db.GetImage(imageID, "")
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
-}
-
-func (rt *_router) likePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	idStr := ps.ByName("imageid")
-	imageID, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("Invalid image id")
-		http.Error(w, "Invalid image id", http.StatusBadRequest)
+	if !requireOwner(w, ctx, image.UserID) {
 		return
 	}
 
-	if err := rt.db.AddLike(imageID); err != nil {
-		ctx.Logger.WithError(err).Error("Failed to like the image")
-		http.Error(w, "Failed to add like to the image", http.StatusInternalServerError)
+	if err := rt.removeImage(ctx, imageID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to delete image")
 		return
 	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
-func (rt *_router) addComment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	var requestBody struct {
-		Comment string `json:"comment"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+func (rt *_router) getImageInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	idStr := ps.ByName("imageid")
+	if idStr == "" {
+		sendError(w, ctx, errInvalidImageID, "missing image ID")
 		return
 	}
 
-	idStr := ps.ByName("imageid")
 	imageID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid image id", http.StatusBadRequest)
+		sendError(w, ctx, errInvalidImageID, "")
 		return
 	}
-	if err := rt.db.AddComment(imageID, requestBody.Comment); err != nil {
-		http.Error(w, "Failed to add comment to the image", http.StatusInternalServerError)
+
+	image, err := rt.db.GetImage(imageID, ctx.UserID)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
 		return
 	}
+	setRawURL(&image)
 
-	w.WriteHeader(http.StatusOK)
-}
-
-func (rt *_router) removeComment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	var requestBody struct {
-		Comment string `json:"comment"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(image); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
 		return
 	}
+}
 
-	idStr := ps.ByName("imageid")
-	imageID, err := strconv.ParseInt(idStr, 10, 64)
+// setCaption replaces the caption of a photo, and replies with the updated photo. Only the owner can change it.
+func (rt *_router) setCaption(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	imageID, err := strconv.ParseInt(ps.ByName("imageid"), 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid image id", http.StatusBadRequest)
+		sendError(w, ctx, errInvalidImageID, "")
 		return
 	}
-	if err := rt.db.RemoveComment(imageID, requestBody.Comment); err != nil {
-		http.Error(w, "Failed to remove comment from the image", http.StatusInternalServerError)
+
+	var requestBody struct {
+		Caption string `json:"caption"`
+	}
+	if !decodeJSON(w, r, ctx, &requestBody) {
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-}
-
-func (rt *_router) getImageInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	w.Header().Set("Content-Type", "application/json")
-
-	idStr := ps.ByName("imageid")
-	if idStr == "" {
-		http.Error(w, "Missing image id in path", http.StatusBadRequest)
+	var v validator
+	v.caption("body", "caption", requestBody.Caption)
+	if !v.valid(w, ctx) {
 		return
 	}
 
-	imageID, err := strconv.ParseInt(idStr, 10, 64)
+	image, err := rt.db.GetImage(imageID, ctx.UserID)
 	if err != nil {
-		http.Error(w, "Invalid image id", http.StatusBadRequest)
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
+		return
+	}
+	if !requireOwner(w, ctx, image.UserID) {
 		return
 	}
 
-	image, err := rt.db.GetImage(imageID)
+	if err := rt.db.UpdateCaption(imageID, requestBody.Caption); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to update the caption")
+		return
+	}
+	image, err = rt.db.GetImage(imageID, ctx.UserID)
 	if err != nil {
-		http.Error(w, "Image not found", http.StatusNotFound)
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
 		return
 	}
+	setRawURL(&image)
 
 	if err := json.NewEncoder(w).Encode(image); err != nil {
-		http.Error(w, "Failed to encode image data", http.StatusInternalServerError)
+		sendError(w, ctx, errInternal, "Failed to encode response")
 		return
 	}
 }
-
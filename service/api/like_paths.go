@@ -0,0 +1,92 @@
+package api
+
+import (
+	"clean/service/api/reqcontext"
+	"clean/service/database"
+	"encoding/json"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+)
+
+// likePhoto adds the like of the user in the path to the image. Liking an image twice has no effect.
+func (rt *_router) likePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	imageID, ok := rt.likeTarget(w, ps, ctx)
+	if !ok {
+		return
+	}
+
+	if err := rt.db.AddLike(imageID, ctx.UserID); errors.Is(err, database.ErrBanned) {
+		sendError(w, ctx, errBanned, "can't like photos of a banned user")
+		return
+	} else if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to add like to the image")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// unlikePhoto removes the like of the user in the path from the image. Removing a missing like has no effect.
+func (rt *_router) unlikePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	imageID, ok := rt.likeTarget(w, ps, ctx)
+	if !ok {
+		return
+	}
+
+	if err := rt.db.RemoveLike(imageID, ctx.UserID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to remove like from the image")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// likeTarget checks that the authenticated user is the one in the path and that the image exists, replying with an
+// error otherwise. It returns the image ID, and true if the handler can go on.
+func (rt *_router) likeTarget(w http.ResponseWriter, ps httprouter.Params, ctx reqcontext.RequestContext) (int64, bool) {
+	if !requirePathOwner(w, ps, ctx) {
+		return 0, false
+	}
+
+	imageID, err := strconv.ParseInt(ps.ByName("imageid"), 10, 64)
+	if err != nil {
+		sendError(w, ctx, errInvalidImageID, "")
+		return 0, false
+	}
+	if _, err := rt.db.GetImage(imageID, ctx.UserID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
+		return 0, false
+	}
+	return imageID, true
+}
+
+// getLikes returns a page of the usernames of the users who like the image
+func (rt *_router) getLikes(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	imageID, err := strconv.ParseInt(ps.ByName("imageid"), 10, 64)
+	if err != nil {
+		sendError(w, ctx, errInvalidImageID, "")
+		return
+	}
+	page, err := pageParams(r)
+	if err != nil {
+		sendError(w, ctx, errInvalidPage, err.Error())
+		return
+	}
+	if _, err := rt.db.GetImage(imageID, ctx.UserID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve the image")
+		return
+	}
+
+	likes, next, err := rt.db.GetLikes(imageID, ctx.UserID, page)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve likes")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(pageResponse{Items: likes, NextCursor: next}); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
+		return
+	}
+}
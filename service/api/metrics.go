@@ -0,0 +1,29 @@
+package api
+
+import (
+	"clean/service/metrics"
+)
+
+// httpMetrics are the metrics of the requests handled by the router
+type httpMetrics struct {
+	// requests counts the requests by route and status code
+	requests *metrics.CounterVec
+
+	// inFlight is the number of requests being handled
+	inFlight *metrics.Gauge
+
+	// duration is the latency of the requests by route and status code
+	duration *metrics.HistogramVec
+}
+
+// newHTTPMetrics creates the request metrics in the registry
+func newHTTPMetrics(registry *metrics.Registry) httpMetrics {
+	return httpMetrics{
+		requests: registry.NewCounterVec("webapi_http_requests_total",
+			"HTTP requests handled, by route and status code.", "route", "code"),
+		inFlight: registry.NewGauge("webapi_http_requests_in_flight",
+			"HTTP requests being handled."),
+		duration: registry.NewHistogramVec("webapi_http_request_duration_seconds",
+			"Latency of HTTP requests, by route and status code.", metrics.DefaultBuckets, "route", "code"),
+	}
+}
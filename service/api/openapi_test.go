@@ -0,0 +1,252 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// specFile is the OpenAPI document describing the API, relative to this package
+const specFile = "../../doc/api.yaml"
+
+// openAPI is the subset of an OpenAPI 3 document needed by the contract tests
+type openAPI struct {
+	doc        map[string]interface{}
+	operations map[string]specOperation
+}
+
+// specOperation is an operation of the document, identified by its operationId
+type specOperation struct {
+	id     string
+	method string
+	path   string
+	node   map[string]interface{}
+}
+
+// loadOpenAPI reads the OpenAPI document and indexes its operations
+func loadOpenAPI(t *testing.T, file string) *openAPI {
+	t.Helper()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("reading the OpenAPI document: %v", err)
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("parsing the OpenAPI document: %v", err)
+	}
+	doc, ok := normalizeYAML(raw).(map[string]interface{})
+	if !ok {
+		t.Fatal("the OpenAPI document is not an object")
+	}
+
+	spec := &openAPI{doc: doc, operations: make(map[string]specOperation)}
+	for path, item := range asMap(doc["paths"]) {
+		for method, op := range asMap(item) {
+			node := asMap(op)
+			id, _ := node["operationId"].(string)
+			if id == "" {
+				continue
+			}
+			if _, dup := spec.operations[id]; dup {
+				t.Fatalf("duplicate operationId %s", id)
+			}
+			spec.operations[id] = specOperation{id: id, method: strings.ToUpper(method), path: path, node: node}
+		}
+	}
+	return spec
+}
+
+// operationIDs returns the IDs of all the operations, sorted
+func (s *openAPI) operationIDs() []string {
+	ids := make([]string, 0, len(s.operations))
+	for id := range s.operations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// resolve follows the `$ref` of the node, if any. Only local references are supported.
+func (s *openAPI) resolve(node interface{}) map[string]interface{} {
+	m := asMap(node)
+	for i := 0; i < 32; i++ {
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+		var target interface{} = s.doc
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			target = asMap(target)[part]
+		}
+		m = asMap(target)
+	}
+	return m
+}
+
+// response returns the declared response of the operation for the status code, or nil
+func (s *openAPI) response(op specOperation, status int) map[string]interface{} {
+	responses := asMap(op.node["responses"])
+	if r, ok := responses[fmt.Sprint(status)]; ok {
+		return s.resolve(r)
+	}
+	if r, ok := responses["default"]; ok {
+		return s.resolve(r)
+	}
+	return nil
+}
+
+// validate checks `value` (decoded from JSON using json.Decoder.UseNumber) against the schema, and returns the
+// violations. Objects are closed unless they declare `additionalProperties`: undocumented fields are violations, so that
+// responses can't drift from the document.
+func (s *openAPI) validate(schema interface{}, value interface{}, at string) []string {
+	sch := s.resolve(schema)
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if enum, ok := sch["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			fail("%v is not one of %v", value, enum)
+		}
+	}
+
+	switch typ, _ := sch["type"].(string); typ {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			fail("expected an object, got %T", value)
+			break
+		}
+		props := asMap(sch["properties"])
+		for _, name := range asSlice(sch["required"]) {
+			if _, ok := obj[fmt.Sprint(name)]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		for name, v := range obj {
+			if p, ok := props[name]; ok {
+				errs = append(errs, s.validate(p, v, at+"."+name)...)
+			} else if additional, ok := sch["additionalProperties"]; !ok || additional == false {
+				fail("undocumented property %q", name)
+			} else if additionalSchema, ok := additional.(map[string]interface{}); ok {
+				errs = append(errs, s.validate(additionalSchema, v, at+"."+name)...)
+			}
+		}
+
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			fail("expected an array, got %T", value)
+			break
+		}
+		for i, v := range arr {
+			errs = append(errs, s.validate(sch["items"], v, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("expected a string, got %T", value)
+			break
+		}
+		n := utf8.RuneCountInString(str)
+		if min, ok := asNumber(sch["minLength"]); ok && float64(n) < min {
+			fail("%q is shorter than %v", str, min)
+		}
+		if max, ok := asNumber(sch["maxLength"]); ok && float64(n) > max {
+			fail("%q is longer than %v", str, max)
+		}
+		if pattern, ok := sch["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			fail("%q doesn't match %s", str, pattern)
+		}
+		if sch["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				fail("%q is not a date-time", str)
+			}
+		}
+
+	case "integer", "number":
+		num, ok := value.(json.Number)
+		if !ok {
+			fail("expected a %s, got %T", typ, value)
+			break
+		}
+		f, err := num.Float64()
+		if err != nil {
+			fail("invalid number %s", num)
+			break
+		}
+		if _, err := num.Int64(); typ == "integer" && err != nil {
+			fail("%s is not an integer", num)
+		}
+		if min, ok := asNumber(sch["minimum"]); ok && f < min {
+			fail("%s is less than %v", num, min)
+		}
+		if max, ok := asNumber(sch["maximum"]); ok && f > max {
+			fail("%s is greater than %v", num, max)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("expected a boolean, got %T", value)
+		}
+
+	case "":
+		// Any value
+
+	default:
+		fail("unsupported schema type %q", typ)
+	}
+	return errs
+}
+
+// normalizeYAML converts the maps decoded by the yaml package into map[string]interface{}, as decoded from JSON
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = normalizeYAML(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+	}
+	return v
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func asSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+func asNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"clean/service/database"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// defaultPageSize is the number of items returned by list endpoints when `limit` is not specified
+	defaultPageSize = 20
+
+	// maxPageSize is the maximum value for `limit` in list endpoints
+	maxPageSize = 100
+)
+
+// pageResponse is the envelope of the responses of list endpoints. NextCursor is omitted on the last page.
+type pageResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// pageParams reads the `limit` and `cursor` query parameters of list endpoints. The cursor is opaque: it's the
+// `nextCursor` value of the previous page.
+func pageParams(r *http.Request) (database.Page, error) {
+	page := database.Page{
+		Limit:  defaultPageSize,
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return page, errors.New("invalid limit")
+		}
+		page.Limit = limit
+	}
+	return page, nil
+}
@@ -16,6 +16,16 @@ type RequestContext struct {
 	// ReqUUID is the request unique ID
 	ReqUUID uuid.UUID
 
+	// ReqID is the ID of the request in logs and in the X-Request-ID response header. It's ReqUUID, unless the ID was
+	// received from a trusted proxy.
+	ReqID string
+
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
+
+	// UserID is the ID of the user authenticated by the bearer token of the request. It's empty for anonymous requests
+	UserID string
+
+	// Username is the current username of the authenticated user. It's empty for anonymous requests
+	Username string
 }
@@ -2,9 +2,13 @@ package api
 
 import (
 	"clean/service/api/reqcontext"
+	"clean/service/database"
 	"encoding/json"
+	"errors"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
@@ -13,30 +17,58 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 		Username string `json:"username"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, ctx, &requestBody) {
+		return
+	}
+	var v validator
+	v.username("body", "username", requestBody.Username)
+	if !v.valid(w, ctx) {
+		return
+	}
+
+	// New users get their ID here
+	user, err := rt.db.GetUser(requestBody.Username)
+	exists := err == nil
+	if errors.Is(err, database.ErrUserNotFound) {
+		v.mentionable("body", "username", requestBody.Username)
+		if !v.valid(w, ctx) {
+			return
+		}
+		user, err = rt.db.AddUser(requestBody.Username)
+	}
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to add user")
 		return
 	}
 
-	exists, _ := rt.db.CheckUsername(requestBody.Username)
+	// Issue a new session token for the user
+	token, err := newSessionToken()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't generate a session token")
+		sendError(w, ctx, errInternal, "Failed to create session")
+		return
+	}
+	if err := rt.db.CreateSession(token, user.ID, rt.sessionLifetime); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to create session")
+		return
+	}
 
 	if exists {
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(map[string]string{
-			"Username": requestBody.Username,
+			"Id":       user.ID,
+			"Username": user.Username,
+			"Token":    token,
 			"Message":  "Successful login into existing account",
 		})
 		return
 	}
 
-	if err := rt.db.AddUser(requestBody.Username); err != nil {
-		http.Error(w, "Failed to add user", http.StatusInternalServerError)
-		return
-	}
-
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(map[string]string{
-		"Username": requestBody.Username,
+		"Id":       user.ID,
+		"Username": user.Username,
+		"Token":    token,
 		"Message":  "Successful sign up and login",
 	})
 }
@@ -44,19 +76,28 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
+	if !requirePathOwner(w, ps, ctx) {
+		return
+	}
+
 	// Decode the request body into a struct
 	var requestBody struct {
 		Username string `json:"username"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, ctx, &requestBody) {
+		return
+	}
+	var v validator
+	v.username("body", "username", requestBody.Username)
+	if len(v.errors) == 0 {
+		v.mentionable("body", "username", requestBody.Username)
+	}
+	if !v.valid(w, ctx) {
 		return
 	}
 
-	username := ps.ByName("username")
-
-	if err := rt.db.UpdateUsername(username, requestBody.Username); err != nil {
-		http.Error(w, "Failed to update username", http.StatusInternalServerError)
+	if err := rt.db.UpdateUsername(ctx.UserID, requestBody.Username, rt.renameGracePeriod); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to update username")
 		return
 	}
 
@@ -66,41 +107,67 @@ func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps http
 func (rt *_router) getUserProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	username := ps.ByName("username")
+	user, ok := rt.pathUser(w, ps, ctx)
+	if !ok {
+		return
+	}
 
-	// Fetch user from the database
-	user, err := rt.db.GetUser(username)
+	// Fetch the profile from the database, as seen by the authenticated user
+	profile, err := rt.db.GetProfile(user.ID, ctx.UserID)
 	if err != nil {
-		if err.Error() == "user not found" {
-			http.Error(w, "User not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
-		}
+		sendDatabaseError(w, ctx, err, "Failed to retrieve user")
 		return
 	}
 
-	// Encode and send user as JSON
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		http.Error(w, "Failed to encode user data", http.StatusInternalServerError)
+	// Encode and send the profile as JSON
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
 		return
 	}
 }
 
+// bodyUser decodes the request body, carrying the username of the target of the request, and looks up the user. On
+// errors, it replies to the request and returns false.
+func (rt *_router) bodyUser(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) (database.User, bool) {
+	var requestBody struct {
+		Username string `json:"username"`
+	}
+	if !decodeJSON(w, r, ctx, &requestBody) {
+		return database.User{}, false
+	}
+	var v validator
+	v.username("body", "username", requestBody.Username)
+	if !v.valid(w, ctx) {
+		return database.User{}, false
+	}
+
+	user, err := rt.db.GetUser(requestBody.Username)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve user")
+		return database.User{}, false
+	}
+	return user, true
+}
+
 func (rt *_router) followUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var requestBody struct {
-		Username string `json:"username"`
+	if !requirePathOwner(w, ps, ctx) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	followed, ok := rt.bodyUser(w, r, ctx)
+	if !ok {
 		return
 	}
 
-	username := ps.ByName("username")
-
-	if err := rt.db.FollowUsername(username, requestBody.Username); err != nil {
-		http.Error(w, "Failed to follow user", http.StatusInternalServerError)
+	if !rt.requireNotBannedBy(w, ctx, followed.ID) {
+		return
+	}
+	if err := rt.db.FollowUser(ctx.UserID, followed.ID); errors.Is(err, database.ErrBanned) {
+		sendError(w, ctx, errBanned, "can't follow a banned user")
+		return
+	} else if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to follow user")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -109,17 +176,16 @@ func (rt *_router) followUser(w http.ResponseWriter, r *http.Request, ps httprou
 func (rt *_router) unfollowUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var requestBody struct {
-		Username string `json:"username"`
+	if !requirePathOwner(w, ps, ctx) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	followed, ok := rt.bodyUser(w, r, ctx)
+	if !ok {
 		return
 	}
 
-	username := ps.ByName("username")
-	if err := rt.db.UnfollowUsername(username, requestBody.Username); err != nil {
-		http.Error(w, "Failed to unfollow user", http.StatusInternalServerError)
+	if err := rt.db.UnfollowUser(ctx.UserID, followed.ID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to unfollow user")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -128,18 +194,16 @@ func (rt *_router) unfollowUser(w http.ResponseWriter, r *http.Request, ps httpr
 func (rt *_router) banUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var requestBody struct {
-		Username string `json:"username"`
+	if !requirePathOwner(w, ps, ctx) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	banned, ok := rt.bodyUser(w, r, ctx)
+	if !ok {
 		return
 	}
 
-	username := ps.ByName("username")
-	if err := rt.db.BanUsername(username, requestBody.Username); err != nil {
-
-		http.Error(w, "Failed to ban user", http.StatusInternalServerError)
+	if err := rt.db.BanUser(ctx.UserID, banned.ID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to ban user")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -148,17 +212,16 @@ func (rt *_router) banUser(w http.ResponseWriter, r *http.Request, ps httprouter
 func (rt *_router) unbanUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var requestBody struct {
-		Username string `json:"username"`
+	if !requirePathOwner(w, ps, ctx) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	banned, ok := rt.bodyUser(w, r, ctx)
+	if !ok {
 		return
 	}
 
-	username := ps.ByName("username")
-	if err := rt.db.UnbanUsername(username, requestBody.Username); err != nil {
-		http.Error(w, "Failed to unban user", http.StatusInternalServerError)
+	if err := rt.db.UnbanUser(ctx.UserID, banned.ID); err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to unban user")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -167,20 +230,80 @@ func (rt *_router) unbanUser(w http.ResponseWriter, r *http.Request, ps httprout
 func (rt *_router) userPhotos(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	username := ps.ByName("username")
-	if username == "" {
-		http.Error(w, "Missing username in path", http.StatusBadRequest)
+	user, ok := rt.pathUser(w, ps, ctx)
+	if !ok {
+		return
+	}
+
+	page, err := pageParams(r)
+	if err != nil {
+		sendError(w, ctx, errInvalidPage, err.Error())
+		return
+	}
+
+	images, next, err := rt.db.GetUserPhotos(user.ID, ctx.UserID, page)
+	if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to retrieve images")
+		return
+	}
+	for i := range images {
+		setRawURL(&images[i])
+	}
+
+	if err := json.NewEncoder(w).Encode(pageResponse{Items: images, NextCursor: next}); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
+		return
+	}
+}
+
+// searchUsers returns a page of the users whose username matches the `q` query parameter, best matches first
+func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		sendError(w, ctx, errMissingQuery, "")
+		return
+	}
+	page, err := pageParams(r)
+	if err != nil {
+		sendError(w, ctx, errInvalidPage, err.Error())
 		return
 	}
 
-	images, err := rt.db.GetUserPhotos(username)
+	users, next, err := rt.db.SearchUsers(query, ctx.UserID, page)
 	if err != nil {
-		http.Error(w, "Failed to retrieve images", http.StatusInternalServerError)
+		sendDatabaseError(w, ctx, err, "Failed to search users")
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(images); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(pageResponse{Items: users, NextCursor: next}); err != nil {
+		sendError(w, ctx, errInternal, "Failed to encode response")
 		return
 	}
 }
+
+// redirectRenamedUser redirects GET requests for the old username of a renamed user, while it's still an alias, to the
+// same path with the current username. Users given by ID are never redirected. It returns true if the request has been
+// handled.
+func (rt *_router) redirectRenamedUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) bool {
+	username := ps.ByName("user")
+	prefix := "/users/" + username
+	if r.Method != http.MethodGet || username == "" || isUserID(username) || rt.renameGracePeriod <= 0 ||
+		(r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/")) {
+		return false
+	}
+
+	current, err := rt.db.ResolveUsername(username)
+	if errors.Is(err, database.ErrUserNotFound) || (err == nil && current == username) {
+		return false
+	} else if err != nil {
+		sendDatabaseError(w, ctx, err, "Failed to resolve the username")
+		return true
+	}
+
+	// The alias may be taken by someone else when it expires: the redirect is temporary
+	target := url.URL{Path: "/users/" + current + strings.TrimPrefix(r.URL.Path, prefix), RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, target.String(), http.StatusTemporaryRedirect)
+	return true
+}
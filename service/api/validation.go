@@ -0,0 +1,224 @@
+package api
+
+import (
+	"clean/service/api/reqcontext"
+	"clean/service/entities"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Constraints on the values sent by clients. They mirror the schemas in doc/api.yaml: keep them in sync.
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 16
+	commentMinLength  = 1
+	commentMaxLength  = 140
+	imageURLMinLength = 8
+	imageURLMaxLength = 140
+	captionMaxLength  = 2200
+)
+
+// maxJSONBodySize is the maximum size in bytes of a JSON request body
+const maxJSONBodySize = 16 << 10
+
+// fieldError describes a value in the request which violates a constraint of the API
+type fieldError struct {
+	// In is where the value is: "path", "query" or "body"
+	In      string `json:"in"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validator collects the constraint violations of a request, so that all of them are replied at once
+type validator struct {
+	errors []fieldError
+}
+
+// add records a violation
+func (v *validator) add(in, field, message string) {
+	v.errors = append(v.errors, fieldError{In: in, Field: field, Message: message})
+}
+
+// text checks that the value has between `min` and `max` characters, and no line breaks (the `^.*?$` pattern of the
+// spec)
+func (v *validator) text(in, field, value string, min, max int) {
+	n := utf8.RuneCountInString(value)
+	switch {
+	case !utf8.ValidString(value):
+		v.add(in, field, "must be valid UTF-8")
+	case n < min:
+		v.add(in, field, fmt.Sprintf("must be at least %d characters long", min))
+	case n > max:
+		v.add(in, field, fmt.Sprintf("must be at most %d characters long", max))
+	case strings.ContainsAny(value, "\r\n"):
+		v.add(in, field, "must not contain line breaks")
+	}
+}
+
+func (v *validator) username(in, field, value string) {
+	v.text(in, field, value, usernameMinLength, usernameMaxLength)
+}
+
+// mentionable checks that the username can be mentioned in captions and comments (see the entities package). It's
+// required for new usernames only: older ones can have other characters.
+func (v *validator) mentionable(in, field, value string) {
+	if !entities.IsUsername(value) {
+		v.add(in, field, "must be letters, digits and underscores, possibly joined by dots and dashes")
+	}
+}
+
+// user checks that the value refers to a user: either a user ID, or a username
+func (v *validator) user(in, field, value string) {
+	if isUserID(value) {
+		return
+	}
+	if n := utf8.RuneCountInString(value); n < usernameMinLength || n > usernameMaxLength {
+		v.add(in, field, fmt.Sprintf("must be a user ID, or a username of %d to %d characters", usernameMinLength,
+			usernameMaxLength))
+		return
+	}
+	v.username(in, field, value)
+}
+
+// isUserID returns true if the value is a user ID: a UUID in its canonical, lowercase form
+func isUserID(value string) bool {
+	id, err := uuid.FromString(value)
+	return err == nil && id.String() == value
+}
+
+func (v *validator) comment(in, field, value string) {
+	v.text(in, field, value, commentMinLength, commentMaxLength)
+}
+
+// imageURL checks the URL of a photo posted by URL (see isRedirectURL)
+func (v *validator) imageURL(in, field, value string) {
+	n := len(v.errors)
+	v.text(in, field, value, imageURLMinLength, imageURLMaxLength)
+	if len(v.errors) == n && !isRedirectURL(value) {
+		v.add(in, field, "must be an absolute http or https URL")
+	}
+}
+
+// isRedirectURL returns true if getImageRaw can redirect to the URL: it must be an absolute http or https URL. Other
+// schemes (e.g., `javascript:`) and relative URLs, which browsers resolve against the API, are refused.
+func isRedirectURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (strings.EqualFold(u.Scheme, "http") || strings.EqualFold(u.Scheme, "https")) && u.Host != "" &&
+		!strings.ContainsAny(value, " \t")
+}
+
+// caption checks the caption of a photo. Unlike other texts, captions can be empty, and they can span several lines.
+func (v *validator) caption(in, field, value string) {
+	switch {
+	case !utf8.ValidString(value):
+		v.add(in, field, "must be valid UTF-8")
+	case utf8.RuneCountInString(value) > captionMaxLength:
+		v.add(in, field, fmt.Sprintf("must be at most %d characters long", captionMaxLength))
+	}
+}
+
+// hashtag checks that the value is a hashtag, with or without the leading `#`
+func (v *validator) hashtag(in, field, value string) {
+	if _, ok := entities.NormalizeHashtag(value); !ok {
+		v.add(in, field, fmt.Sprintf("must be a hashtag: up to %d letters, digits and underscores, not all digits",
+			entities.MaxHashtagLength))
+	}
+}
+
+// id checks that the value is a positive integer
+func (v *validator) id(in, field, value string) {
+	if id, err := strconv.ParseInt(value, 10, 64); err != nil || id < 1 {
+		v.add(in, field, "must be a positive integer")
+	}
+}
+
+// valid replies with the violations, if any. It returns true if the request is valid.
+func (v *validator) valid(w http.ResponseWriter, ctx reqcontext.RequestContext) bool {
+	if len(v.errors) == 0 {
+		return true
+	}
+	sendValidationError(w, ctx, v.errors)
+	return false
+}
+
+// validatePath checks the path parameters of the request. Each parameter has the same constraints in all routes.
+func validatePath(ps httprouter.Params) *validator {
+	var v validator
+	for _, p := range ps {
+		switch p.Key {
+		case "user":
+			v.user("path", p.Key, p.Value)
+		case "imageid", "commentid":
+			v.id("path", p.Key, p.Value)
+		case "tag":
+			v.hashtag("path", p.Key, p.Value)
+		}
+	}
+	return &v
+}
+
+// decodeJSON decodes the JSON object in the request body into `dst`. Bodies larger than maxJSONBodySize, unknown fields
+// and trailing data are refused. On errors, it replies to the request and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, dst interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		// The body must contain a single JSON value
+		if _, extra := dec.Token(); !errors.Is(extra, io.EOF) {
+			err = errors.New("unexpected data after the JSON object")
+		}
+	}
+
+	var tooLarge *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &tooLarge):
+		sendError(w, ctx, errRequestTooLarge, fmt.Sprintf("the body must be at most %d bytes", maxJSONBodySize))
+	case errors.Is(err, io.EOF):
+		sendError(w, ctx, errInvalidBody, "empty body")
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		sendError(w, ctx, errInvalidBody, "malformed JSON")
+	case errors.As(err, &typeErr):
+		sendValidationError(w, ctx, []fieldError{{In: "body", Field: typeErr.Field, Message: "must be " + jsonType(typeErr.Type.Kind())}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		sendValidationError(w, ctx, []fieldError{{In: "body", Field: field, Message: "unknown field"}})
+	default:
+		sendError(w, ctx, errInvalidBody, err.Error())
+	}
+	return false
+}
+
+// jsonType describes the JSON type matching a Go kind, for error messages
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
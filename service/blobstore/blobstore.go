@@ -0,0 +1,54 @@
+/*
+Package blobstore stores the binary content (blobs) of uploaded photos. The database only keeps the key of each blob,
+while the bytes are saved by an implementation of Store.
+
+The package provides a Store backed by a directory in the local filesystem. To use it, create a new instance with
+NewFileStore passing the directory (from config), and then pass it to the api package:
+
+	blobs, err := blobstore.NewFileStore(cfg.Storage.Directory)
+	if err != nil {
+		logger.WithError(err).Error("error creating the blob store")
+		return fmt.Errorf("creating the blob store: %w", err)
+	}
+*/
+package blobstore
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrBlobNotFound is returned when a blob with the given key does not exist in the store
+var ErrBlobNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned when a key contains characters that are not allowed in blob keys
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store is the interface for the storage of photo blobs. Blobs are immutable: once written with Put, a key always refers
+// to the same content until it's deleted.
+type Store interface {
+	// Put reads everything from r and saves it under the given key. It returns the number of bytes written.
+	Put(key string, r io.Reader) (int64, error)
+
+	// Open returns a reader for the blob with the given key, or ErrBlobNotFound.
+	Open(key string) (io.ReadSeekCloser, error)
+
+	// Delete removes the blob with the given key. Deleting a missing blob is not an error.
+	Delete(key string) error
+}
+
+// validKey checks that the key is made only of letters, digits, dashes and underscores, so it can be safely used as a
+// file name.
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package blobstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// fileStore is a Store that saves each blob as a file inside a directory
+type fileStore struct {
+	dir string
+}
+
+// NewFileStore returns a Store saving blobs in the directory `dir`. The directory is created if it doesn't exist.
+func NewFileStore(dir string) (Store, error) {
+	if dir == "" {
+		return nil, errors.New("directory is required when building a file blob store")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating blob directory: %w", err)
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) Put(key string, r io.Reader) (int64, error) {
+	if !validKey(key) {
+		return 0, ErrInvalidKey
+	}
+
+	// Write into a temporary file first, so a failed upload never leaves a partial blob under the final name
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		// No-op if the file has been renamed already
+		_ = os.Remove(tmp.Name())
+	}()
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		_ = tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, key)); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (s *fileStore) Open(key string) (io.ReadSeekCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	fp, err := os.Open(filepath.Join(s.dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return fp, err
+}
+
+func (s *fileStore) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(filepath.Join(s.dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestStore returns a file store in a new temporary directory
+func newTestStore(t *testing.T) (Store, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "blobs")
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+// readBlob returns the content of the blob, failing the test if it can't be read
+func readBlob(t *testing.T, store Store, key string) string {
+	t.Helper()
+	blob, err := store.Open(key)
+	if err != nil {
+		t.Fatalf("opening %s: %v", key, err)
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("reading %s: %v", key, err)
+	}
+	return string(data)
+}
+
+func TestFileStore(t *testing.T) {
+	store, dir := newTestStore(t)
+
+	n, err := store.Put("photo-1", strings.NewReader("first photo"))
+	if err != nil || n != int64(len("first photo")) {
+		t.Fatalf("expected %d bytes written, got %d (%v)", len("first photo"), n, err)
+	}
+	if got := readBlob(t, store, "photo-1"); got != "first photo" {
+		t.Errorf("expected %q, got %q", "first photo", got)
+	}
+
+	// Blobs can be read from any position, for range requests
+	blob, err := store.Open("photo-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if rest, err := io.ReadAll(blob); err != nil || string(rest) != "photo" {
+		t.Errorf("expected %q after seeking, got %q (%v)", "photo", rest, err)
+	}
+	_ = blob.Close()
+
+	if err := store.Delete("photo-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open("photo-1"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound after the deletion, got %v", err)
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Errorf("expected an empty directory, got %d files", len(entries))
+	}
+}
+
+func TestFileStoreSameKey(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	// Blobs are addressed by content, so the same key is saved again with the same content by identical uploads
+	for i := 0; i < 2; i++ {
+		if _, err := store.Put("same", strings.NewReader("content")); err != nil {
+			t.Fatalf("put %d: %v", i+1, err)
+		}
+		if got := readBlob(t, store, "same"); got != "content" {
+			t.Errorf("put %d: expected %q, got %q", i+1, "content", got)
+		}
+	}
+
+	// A reader opened before the blob is saved again keeps reading the whole blob
+	blob, err := store.Open("same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	if _, err := store.Put("same", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(blob); err != nil || string(data) != "content" {
+		t.Errorf("expected %q from the open blob, got %q (%v)", "content", data, err)
+	}
+}
+
+func TestFileStoreMissingKey(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	if _, err := store.Open("missing"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+	// Deleting a missing blob is not an error, so that deletions can be retried
+	if err := store.Delete("missing"); err != nil {
+		t.Errorf("expected no error deleting a missing blob, got %v", err)
+	}
+}
+
+func TestFileStoreInvalidKey(t *testing.T) {
+	store, dir := newTestStore(t)
+
+	for _, key := range []string{"", "../escape", "a/b", ".hidden", "with space"} {
+		if _, err := store.Put(key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+		if _, err := store.Open(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Open(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+		if err := store.Delete(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "..", "escape")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no file outside the directory, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"clean/service/globaltime"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrCommentNotFound is returned when a comment with the given ID does not exist
+var ErrCommentNotFound = errors.New("comment not found")
+
+type Comment struct {
+	ID        int64     `json:"commentId"`
+	ImageID   int64     `json:"imageId"`
+	UserID    string    `json:"userId"`
+	Username  string    `json:"username"`
+	Body      string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Mentions are the users mentioned in the comment, in order of appearance
+	Mentions []Mention `json:"mentions"`
+}
+
+// commentColumns is the list of columns read by scanComment, from Comments joined with their authors (see
+// commentsFrom). The author of comments created before authors were recorded is empty.
+const commentColumns = `Comments.id, Comments.image_id, COALESCE(Comments.user_id, ''), COALESCE(Users.username, ''),
+	Comments.body, Comments.created_at, CAST(Comments.created_at AS TEXT)`
+
+// commentsFrom is the FROM clause of the queries reading commentColumns
+const commentsFrom = " FROM Comments LEFT JOIN Users ON Users.id = Comments.user_id"
+
+// commentsOldestFirst is the order of lists of comments
+var commentsOldestFirst = pageOrder{createdAt: "Comments.created_at", id: "Comments.id", numericID: true}
+
+// scanComment reads a Comment from a row selected with commentColumns. It also returns the position of the comment for
+// pagination.
+func scanComment(row rowScanner) (Comment, cursor, error) {
+	var comment Comment
+	var createdAt string
+	err := row.Scan(&comment.ID, &comment.ImageID, &comment.UserID, &comment.Username, &comment.Body, &comment.CreatedAt, &createdAt)
+	return comment, cursor{createdAt: createdAt, id: strconv.FormatInt(comment.ID, 10)}, err
+}
+
+// AddComment adds a comment by `userID` to the image, with its mentions, returning the new comment. It returns ErrBanned
+// if the user and the owner of the image banned each other.
+func (db *appdbimpl) AddComment(imageID int64, userID, comment string) (Comment, error) {
+	if banned, err := db.isBannedFromImage(imageID, userID); err != nil {
+		return Comment{}, err
+	} else if banned {
+		return Comment{}, ErrBanned
+	}
+	var id int64
+	err := inTransaction(db.c, func(tx *sql.Tx) error {
+		res, err := tx.Exec("INSERT INTO Comments (image_id, user_id, body, created_at) VALUES (?, ?, ?, ?)",
+			imageID, userID, comment, globaltime.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		return setMentions(tx, commentMentions, id, comment)
+	})
+	if err != nil {
+		return Comment{}, err
+	}
+	return db.GetComment(id)
+}
+
+// GetComment returns the comment with the given ID, or ErrCommentNotFound
+func (db *appdbimpl) GetComment(commentID int64) (Comment, error) {
+	comment, _, err := scanComment(db.c.QueryRow("SELECT "+commentColumns+commentsFrom+" WHERE Comments.id = ?", commentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Comment{}, ErrCommentNotFound
+	} else if err != nil {
+		return Comment{}, err
+	}
+	comments := []Comment{comment}
+	if err := db.loadCommentMentions(comments); err != nil {
+		return Comment{}, err
+	}
+	return comments[0], nil
+}
+
+// GetComments returns a page of the comments of the image, oldest first. Comments of users who banned `viewerID`, or
+// were banned by `viewerID`, are left out.
+func (db *appdbimpl) GetComments(imageID int64, viewerID string, page Page) ([]Comment, string, error) {
+	clause, args, err := commentsOldestFirst.clause("Comments.image_id = ? AND "+notBannedEither("Comments.user_id"),
+		[]interface{}{imageID, viewerID, viewerID}, page)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := db.c.Query("SELECT "+commentColumns+commentsFrom+clause, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var comments = []Comment{}
+	var keys []cursor
+	for rows.Next() {
+		comment, key, err := scanComment(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		comments = append(comments, comment)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	n, next := nextPage(keys, page)
+	comments = comments[:n]
+	if err := db.loadCommentMentions(comments); err != nil {
+		return nil, "", err
+	}
+	return comments, next, nil
+}
+
+// RemoveComment deletes the comment with the given ID
+func (db *appdbimpl) RemoveComment(commentID int64) error {
+	_, err := db.c.Exec("DELETE FROM Comments WHERE id = ?", commentID)
+	return err
+}
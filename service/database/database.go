@@ -2,8 +2,9 @@
 Package database is the middleware between the app database and the code. All data (de)serialization (save/load) from a
 persistent database are handled here. Database specific logic should never escape this package.
 
-To use this package you need to apply migrations to the database if needed/wanted, connect to it (using the database
-data source name from config), and then initialize an instance of AppDatabase from the DB connection.
+To use this package you need to connect to the database (using the database data source name from config), and then
+initialize an instance of AppDatabase from the DB connection. New applies any pending migration (see MigrateUp): the
+database structure is described by the ordered SQL scripts in the `migrations/` directory, embedded in the executable.
 
 For example, this code adds a parameter in `webapi` executable for the database data source name (add it to the
 main.WebAPIConfiguration structure):
@@ -16,7 +17,7 @@ This is an example on how to migrate the DB and connect to it:
 
 	// Start Database
 	logger.Println("initializing database support")
-	db, err := sql.Open("sqlite3", "./foo.db")
+	db, err := sql.Open("sqlite3", "./foo.db?_foreign_keys=on")
 	if err != nil {
 		logger.WithError(err).Error("error opening SQLite DB")
 		return fmt.Errorf("opening SQLite: %w", err)
@@ -26,7 +27,9 @@ This is an example on how to migrate the DB and connect to it:
 		_ = db.Close()
 	}()
 
-Then you can initialize the AppDatabase and pass it to the api package.
+Then you can initialize the AppDatabase (passing the logger for the migration messages) and pass it to the api package:
+
+	appdb, err := database.New(db, logger)
 */
 package database
 
@@ -35,30 +38,46 @@ import (
 	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"os"
+	"time"
 )
 
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
-	CheckUsername(username string) (bool, error)
-	AddUser(username string) error
-	UpdateUsername(oldUsername, newUsername string) error
-	GetUser(username string) (User, error)
-	FollowUsername(username, followingUsername string) error
-	UnfollowUsername(username, unfollowingusername string) error
-	BanUsername(username, banusername string) error
-	UnbanUsername(username, unbanusername string) error
-	GetUserPhotos(username string) ([]Image, error)
-	
-	GetStream(username string) ([]Image, error)
-	InsertImage(imageURL, username string) (int64, error)
-	RemoveImage(imageID int64) error
-	AddLike(imageID int64) error
-	RemoveLike(imageID int64) error
-	AddComment(imageID int64, comment string) error
-	RemoveComment(imageID int64, commentToRemove string) error
-	GetImage(imageID int64) (Image, error)
-	
+	GetUser(idOrUsername string) (User, error)
+	AddUser(username string) (User, error)
+	UpdateUsername(userID, newUsername string, aliasFor time.Duration) error
+	ResolveUsername(username string) (string, error)
+	GetProfile(userID, viewerID string) (Profile, error)
+	FollowUser(userID, followedID string) error
+	UnfollowUser(userID, followedID string) error
+	BanUser(userID, bannedID string) error
+	UnbanUser(userID, bannedID string) error
+	IsBanned(userID, bannerID string) (bool, error)
+	GetFollowers(userID, viewerID string, page Page) ([]UserSummary, string, error)
+	GetFollowing(userID, viewerID string, page Page) ([]UserSummary, string, error)
+	SearchUsers(query, viewerID string, page Page) ([]UserSummary, string, error)
+	GetUserPhotos(userID, viewerID string, page Page) ([]Image, string, error)
+
+	GetStream(userID, viewerID string, page Page) ([]Image, string, error)
+	InsertImage(imageURL, userID, caption string) (int64, error)
+	InsertImageBlob(userID, blobKey, contentType, caption string, metadata PhotoMetadata, variants []Variant,
+		save func() error) (int64, error)
+	UpdateCaption(imageID int64, caption string) error
+	RemoveImage(imageID int64, release func(blobKeys []string)) error
+	AddLike(imageID int64, userID string) error
+	RemoveLike(imageID int64, userID string) error
+	GetLikes(imageID int64, viewerID string, page Page) ([]string, string, error)
+	AddComment(imageID int64, userID, comment string) (Comment, error)
+	GetComment(commentID int64) (Comment, error)
+	GetComments(imageID int64, viewerID string, page Page) ([]Comment, string, error)
+	RemoveComment(commentID int64) error
+	GetImage(imageID int64, viewerID string) (Image, error)
+	GetHashtagPhotos(tag, viewerID string, page Page) ([]Image, string, error)
+	SearchHashtags(prefix, viewerID string, page Page) ([]HashtagSummary, string, error)
+
+	CreateSession(token, userID string, lifetime time.Duration) error
+	GetSessionUser(token string) (User, error)
+
 	Ping() error
 }
 
@@ -67,76 +86,29 @@ type appdbimpl struct {
 }
 
 // New returns a new instance of AppDatabase based on the SQLite connection `db`.
-// `db` is required - an error will be returned if `db` is `nil`.
-func New(db *sql.DB) (AppDatabase, error) {
+// `db` is required - an error will be returned if `db` is `nil`. Foreign keys must be enabled on the connection (e.g.,
+// using `_foreign_keys=on` in the data source name). `logger` receives the messages about the migrations; it's required.
+func New(db *sql.DB, logger logrus.FieldLogger) (AppDatabase, error) {
 	if db == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
-
-	logger := logrus.New()
-	logger.SetOutput(os.Stdout)
-
-	logger.Infof("Loading Table Users")
-
-	var tableName string
-	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='Users';`).Scan(&tableName)
-	if errors.Is(err, sql.ErrNoRows) {
-		logger.Infof("No TABLE Users, Initializing")
-		sqlStmt := `CREATE TABLE Users (
-						username TEXT PRIMARY KEY,
-						following TEXT,
-						banned TEXT
-					);`
-		_, err := db.Exec(sqlStmt)
-		if err != nil {
-			return nil, fmt.Errorf("error creating database structure: %w", err)
-		}
+	if logger == nil {
+		return nil, errors.New("logger is required when building a AppDatabase")
 	}
 
-	// logger.Infof("Inserting test user")
-	// _, err = db.Exec(`INSERT INTO Users (username, following, banned) VALUES (?, ?, ?)`,
-	// 	"testuser", "alice,bob", "")
-	// if err != nil {
-	// 	return nil, fmt.Errorf("error inserting test user: %w", err)
-	// }
-
-	logger.Infof("Loading Table Images")
-
-	var tableImagesName string
-	errs := db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='Images';`).Scan(&tableImagesName)
-	if errors.Is(errs, sql.ErrNoRows) {
-		logger.Infof("No TABLE Images, Initializing")
-		sqlStmt := `CREATE TABLE Images (
-						id INTEGER PRIMARY KEY AUTOINCREMENT,
-						imageurl TEXT UNIQUE,
-						username TEXT,
-						likes INTEGER,
-						comments TEXT,
-						created_at DATETIME
-				);`
-		_, err = db.Exec(sqlStmt)
-		if err != nil {
-			return nil, fmt.Errorf("error creating database structure: %w", err)
-		}
+	// Relations between tables rely on ON DELETE CASCADE
+	var foreignKeys bool
+	if err := db.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+		return nil, fmt.Errorf("checking foreign keys support: %w", err)
+	}
+	if !foreignKeys {
+		return nil, errors.New("foreign keys are not enabled on the database connection")
 	}
 
-	// logger.Infof("Inserting test images")
-	// _, err = db.Exec(`INSERT INTO Images (imageurl, username, likes, comments, created_at)
-	// 				VALUES
-	// 				(?, ?, ?, ?, datetime('now', '-1 hour')),
-	// 				(?, ?, ?, ?, datetime('now', '-2 hour'))`,
-	// 	"https://i.guim.co.uk/img/media/c8b1d78883dfbe7cd3f112495941ebc0b25d2265/256_0_3840_2304/master/3840.jpg?width=1200&height=1200&quality=85&auto=format&fit=crop&s=3ac0dfad4c2edc23c843d59f1ec08cc8", "alice", 10, "YES",
-	// 	"https://img.freepik.com/foto-gratuito/rappresentazione-3d-del-ragazzo-del-fumetto_23-2150797600.jpg?semt=ais_hybrid&w=740", "bob", 5, "LMAO")
-	// if err != nil {
-	// 	return nil, fmt.Errorf("error inserting test images: %w", err)
-	// }
-
-	// if _, err := db.Exec(`DROP TABLE IF EXISTS Images`); err != nil {
-	//     return nil, fmt.Errorf("dropping Images table: %w", err)
-	// }
-	// if _, err := db.Exec(`DROP TABLE IF EXISTS Users`); err != nil {
-	//     return nil, fmt.Errorf("dropping Users table: %w", err)
-	// }
+	// Update the database structure to the latest version
+	if err := MigrateUp(db, logger); err != nil {
+		return nil, fmt.Errorf("error migrating database structure: %w", err)
+	}
 
 	return &appdbimpl{
 		c: db,
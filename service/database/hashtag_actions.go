@@ -0,0 +1,105 @@
+package database
+
+import (
+	"clean/service/entities"
+	"database/sql"
+	"strconv"
+)
+
+// HashtagSummary is a hashtag, with the number of photos using it
+type HashtagSummary struct {
+	Tag    string `json:"tag"`
+	Photos int    `json:"photos"`
+}
+
+// setHashtags replaces the hashtags of the image with the ones in `caption`
+func setHashtags(tx *sql.Tx, imageID int64, caption string) error {
+	if _, err := tx.Exec("DELETE FROM Hashtags WHERE image_id = ?", imageID); err != nil {
+		return err
+	}
+	for _, tag := range entities.Hashtags(caption) {
+		if _, err := tx.Exec("INSERT INTO Hashtags (tag, image_id) VALUES (?, ?)", tag, imageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateCaption replaces the caption of the image, and its hashtags and mentions. It returns ErrImageNotFound if the image doesn't
+// exist.
+func (db *appdbimpl) UpdateCaption(imageID int64, caption string) error {
+	return inTransaction(db.c, func(tx *sql.Tx) error {
+		res, err := tx.Exec("UPDATE Images SET caption = ? WHERE id = ?", caption, imageID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrImageNotFound
+		}
+		if err := setHashtags(tx, imageID, caption); err != nil {
+			return err
+		}
+		return setMentions(tx, captionMentions, imageID, caption)
+	})
+}
+
+// GetHashtagPhotos returns a page of the images whose caption has the hashtag (normalized, see the entities package),
+// newest first, as seen by `viewerID`. Images of users who banned the viewer, or were banned by the viewer, are left
+// out.
+func (db *appdbimpl) GetHashtagPhotos(tag, viewerID string, page Page) ([]Image, string, error) {
+	return db.queryImages(viewerID, `Images.id IN (SELECT image_id FROM Hashtags WHERE tag = ?)
+		AND NOT EXISTS(SELECT 1 FROM Bans WHERE Bans.banner = ? AND Bans.banned = Images.user_id)`,
+		[]interface{}{tag, viewerID}, page)
+}
+
+// SearchHashtags returns a page of the hashtags starting with `prefix` (normalized, without `#`), the most used first.
+// All the hashtags match an empty prefix. As for GetHashtagPhotos, the photos of users who banned `viewerID`, or were
+// banned by `viewerID`, are not counted.
+//
+// As for SearchUsers, cursors for the next page are encoded with the number of photos in place of the creation time.
+func (db *appdbimpl) SearchHashtags(prefix, viewerID string, page Page) ([]HashtagSummary, string, error) {
+	args := []interface{}{escapeLike(prefix) + "%", viewerID}
+
+	having := ""
+	c, ok, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if ok {
+		photos, err := strconv.Atoi(c.createdAt)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		having = " HAVING COUNT(*) < ?3 OR (COUNT(*) = ?3 AND tag > ?4)"
+		args = append(args, photos, c.id)
+	}
+
+	rows, err := db.c.Query(`SELECT tag, COUNT(*) FROM Hashtags JOIN Images ON Images.id = Hashtags.image_id
+		WHERE tag LIKE ?1 ESCAPE '\' AND NOT EXISTS(SELECT 1 FROM Bans
+			WHERE (Bans.banner = Images.user_id AND Bans.banned = ?2) OR (Bans.banner = ?2 AND Bans.banned = Images.user_id))
+		GROUP BY tag`+having+
+		" ORDER BY COUNT(*) DESC, tag ASC LIMIT "+strconv.Itoa(page.Limit+1), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var tags = []HashtagSummary{}
+	var keys []cursor
+	for rows.Next() {
+		var tag HashtagSummary
+		if err := rows.Scan(&tag.Tag, &tag.Photos); err != nil {
+			return nil, "", err
+		}
+		tags = append(tags, tag)
+		keys = append(keys, cursor{createdAt: strconv.Itoa(tag.Photos), id: tag.Tag})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	n, next := nextPage(keys, page)
+	return tags[:n], next, nil
+}
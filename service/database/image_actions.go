@@ -1,176 +1,425 @@
 package database
 
 import (
-	"fmt"
+	"clean/service/globaltime"
+	"database/sql"
+	"errors"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// ErrImageNotFound is returned when an image with the given ID does not exist, or it's hidden to the viewer
+var ErrImageNotFound = errors.New("image not found")
+
 type Image struct {
 	ID        int64     `json:"id"`
 	ImageURL  string    `json:"imageurl"`
+	UserID    string    `json:"userId"`
 	Username  string    `json:"username"`
 	Likes     int       `json:"likes"`
-	Comments  string    `json:"comments"`
+	Comments  int       `json:"comments"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// Caption is written by the owner, empty if there's none
+	Caption string `json:"caption"`
+
+	// LikedByMe is true if the user viewing the image likes it
+	LikedByMe bool `json:"likedByMe"`
+
+	// BlobKey is the key of the uploaded photo in the blob store, shared by the images with the same content. It's empty
+	// for photos posted by URL
+	BlobKey string `json:"-"`
+
+	// ContentType is the MIME type of the uploaded photo
+	ContentType string `json:"-"`
+
+	// Variants are the resized copies of the uploaded photo, by width. It's empty for photos posted by URL.
+	Variants map[int]Variant `json:"variants"`
+
+	// Mentions are the users mentioned in the caption, in order of appearance
+	Mentions []Mention `json:"mentions"`
+
+	PhotoMetadata
 }
 
-func (db *appdbimpl) GetStream(username string) ([]Image, error) {
-	var following string
-	err := db.c.QueryRow("SELECT following FROM Users WHERE username = ?", username).Scan(&following)
-	if err != nil {
-		return nil, err
-	}
+// PhotoMetadata is the metadata of an uploaded photo that the owner chose to publish. The fields are empty if the
+// metadata was stripped, or the photo didn't have it.
+type PhotoMetadata struct {
+	TakenAt     *time.Time `json:"takenAt,omitempty"`
+	CameraModel string     `json:"cameraModel,omitempty"`
+}
 
-	// Split and clean the following list
-	usernames := strings.Split(following, ",")
-	var cleaned []string
-	for _, u := range usernames {
-		u = strings.TrimSpace(u)
-		if u != "" {
-			cleaned = append(cleaned, u)
-		}
-	}
+// Variant is a resized copy of an uploaded photo
+type Variant struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
 
-	if len(cleaned) == 0 {
-		return []Image{}, nil // User follows no one
-	}
+	// URL is the address of the variant. It's set by the API, as for ImageURL.
+	URL string `json:"url"`
 
-	// Build placeholders (?, ?, ...) and args
-	placeholders := make([]string, len(cleaned))
-	args := make([]interface{}, len(cleaned))
-	for i, u := range cleaned {
-		placeholders[i] = "?"
-		args[i] = u
-	}
+	BlobKey     string `json:"-"`
+	ContentType string `json:"-"`
+}
+
+// imageColumns is the list of columns read by scanImage. Likes and comments are counted from their tables. The columns
+// have a placeholder for the ID of the viewer, which must be the first query argument. The owner is read from Users,
+// which must be joined (see imagesFrom).
+const imageColumns = `Images.id, COALESCE(Images.imageurl, ''), Images.user_id, Users.username,
+	(SELECT COUNT(*) FROM Likes WHERE Likes.image_id = Images.id),
+	(SELECT COUNT(*) FROM Comments WHERE Comments.image_id = Images.id),
+	Images.created_at, COALESCE(Images.blobkey, ''), COALESCE(Images.contenttype, ''),
+	Images.taken_at, COALESCE(Images.camera_model, ''), COALESCE(Images.caption, ''),
+	EXISTS(SELECT 1 FROM Likes WHERE Likes.image_id = Images.id AND Likes.user_id = ?),
+	CAST(Images.created_at AS TEXT)`
+
+// imageVisible is a condition on Images, true if the owner of the image didn't ban the viewer (the placeholder)
+const imageVisible = "NOT EXISTS(SELECT 1 FROM Bans WHERE Bans.banner = Images.user_id AND Bans.banned = ?)"
 
-	query := fmt.Sprintf(
-		"SELECT id, imageurl, username, likes, comments, created_at FROM Images WHERE username IN (%s) ORDER BY created_at DESC LIMIT 10",
-		strings.Join(placeholders, ","),
-	)
+// notBannedEither returns a condition true if the user in `column` didn't ban the viewer and wasn't banned by the
+// viewer. It has two placeholders for the ID of the viewer.
+func notBannedEither(column string) string {
+	return "NOT EXISTS(SELECT 1 FROM Bans WHERE (Bans.banner = " + column + " AND Bans.banned = ?)" +
+		" OR (Bans.banner = ? AND Bans.banned = " + column + "))"
+}
+
+// imagesFrom is the FROM clause of the queries reading imageColumns
+const imagesFrom = " FROM Images JOIN Users ON Users.id = Images.user_id"
+
+// imagesNewestFirst is the order of lists of images
+var imagesNewestFirst = pageOrder{createdAt: "Images.created_at", id: "Images.id", numericID: true, desc: true}
 
-	rows, err := db.c.Query(query, args...)
+// rowScanner is implemented by both sql.Row and sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanImage reads an Image from a row selected with imageColumns. It also returns the position of the image for
+// pagination.
+func scanImage(row rowScanner) (Image, cursor, error) {
+	var image Image
+	var createdAt string
+	err := row.Scan(&image.ID, &image.ImageURL, &image.UserID, &image.Username, &image.Likes, &image.Comments, &image.CreatedAt,
+		&image.BlobKey, &image.ContentType, &image.TakenAt, &image.CameraModel, &image.Caption, &image.LikedByMe, &createdAt)
+	return image, cursor{createdAt: createdAt, id: strconv.FormatInt(image.ID, 10)}, err
+}
+
+// queryImages returns a page of the images matching `where` (with arguments `args`), newest first, as seen by
+// `viewerID`. Images whose owner banned the viewer are left out.
+func (db *appdbimpl) queryImages(viewerID, where string, args []interface{}, page Page) ([]Image, string, error) {
+	where = "(" + where + ") AND " + imageVisible
+	args = append(args[:len(args):len(args)], viewerID)
+	clause, args, err := imagesNewestFirst.clause(where, args, page)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := db.c.Query("SELECT "+imageColumns+imagesFrom+clause, append([]interface{}{viewerID}, args...)...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
-	var images []Image
+	var images = []Image{}
+	var keys []cursor
 	for rows.Next() {
-		var image Image
-		err := rows.Scan(&image.ID, &image.ImageURL, &image.Username, &image.Likes, &image.Comments, &image.CreatedAt)
+		image, key, err := scanImage(rows)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		images = append(images, image)
+		keys = append(keys, key)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return images, nil
+	n, next := nextPage(keys, page)
+	images = images[:n]
+	if err := db.loadVariants(images); err != nil {
+		return nil, "", err
+	}
+	if err := db.loadImageMentions(images); err != nil {
+		return nil, "", err
+	}
+	return images, next, nil
 }
 
-func (db *appdbimpl) InsertImage(imageURL, username string) (int64, error) {
-	// Get the current time
-	currentTime := time.Now()
-
-	// Execute the INSERT query to insert the image URL into the Images table
-	res, err := db.c.Exec("INSERT INTO Images (imageurl, username, likes, comments, created_at) VALUES (?, ?, ?, ?, ?)", imageURL, username, 0, "", currentTime)
-	if err != nil {
-		return 0, err
+// loadVariants reads the variants of the images, with a single query
+func (db *appdbimpl) loadVariants(images []Image) error {
+	if len(images) == 0 {
+		return nil
 	}
-	id, err := res.LastInsertId()
-	if err != nil {
-		return 0, err
+	byID := make(map[int64]*Image, len(images))
+	args := make([]interface{}, len(images))
+	for i := range images {
+		images[i].Variants = make(map[int]Variant)
+		byID[images[i].ID] = &images[i]
+		args[i] = images[i].ID
 	}
-	return id, nil
-}
 
-func (db *appdbimpl) RemoveImage(imageID int64) error {
-	// Execute the DELETE query to remove the entry associated with the given image URL
-	_, err := db.c.Exec("DELETE FROM Images WHERE id = ?", imageID)
+	rows, err := db.c.Query(`SELECT image_id, width, height, blobkey, contenttype FROM ImageVariants
+		WHERE image_id IN (?`+strings.Repeat(", ?", len(images)-1)+`)`, args...)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer rows.Close()
+	for rows.Next() {
+		var imageID int64
+		var v Variant
+		if err := rows.Scan(&imageID, &v.Width, &v.Height, &v.BlobKey, &v.ContentType); err != nil {
+			return err
+		}
+		byID[imageID].Variants[v.Width] = v
+	}
+	return rows.Err()
 }
 
-func (db *appdbimpl) AddLike(imageID int64) error {
-	_, err := db.c.Exec("UPDATE Images SET likes = likes + 1 WHERE id = ?", imageID)
-	if err != nil {
-		return err
+// GetStream returns a page of the images posted by the users followed by `userID`, newest first. Images of users who
+// banned `userID`, or were banned by `userID`, are left out.
+func (db *appdbimpl) GetStream(userID, viewerID string, page Page) ([]Image, string, error) {
+	if err := db.checkUsersExist(userID); err != nil {
+		return nil, "", err
 	}
-	return nil
+	return db.queryImages(viewerID, `Images.user_id IN (SELECT followed FROM Follows WHERE follower = ?)
+		AND NOT EXISTS(SELECT 1 FROM Bans WHERE (Bans.banner = Images.user_id AND Bans.banned = ?)
+			OR (Bans.banner = ? AND Bans.banned = Images.user_id))`,
+		[]interface{}{userID, userID, userID}, page)
 }
 
-func (db *appdbimpl) RemoveLike(imageID int64) error {
-	// Execute the UPDATE query to decrement the number of likes for the corresponding image
-	_, err := db.c.Exec("UPDATE Images SET likes = likes - 1 WHERE id = ?", imageID)
-	if err != nil {
-		return err
+// InsertImage inserts a photo posted by URL, with its caption (possibly empty) and the hashtags and mentions of the
+// caption
+func (db *appdbimpl) InsertImage(imageURL, userID, caption string) (int64, error) {
+	var id int64
+	err := inTransaction(db.c, func(tx *sql.Tx) error {
+		// Execute the INSERT query to insert the image URL into the Images table
+		res, err := tx.Exec("INSERT INTO Images (imageurl, user_id, created_at, caption) VALUES (?, ?, ?, ?)",
+			imageURL, userID, globaltime.Now().UTC(), caption)
+		if err != nil {
+			return err
+		}
+		if id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		if err := setHashtags(tx, id, caption); err != nil {
+			return err
+		}
+		return setMentions(tx, captionMentions, id, caption)
+	})
+	return id, err
+}
+
+// InsertImageBlob inserts a photo uploaded by the user, whose content is saved in the blob store under `blobKey`, along
+// with its variants, its caption and the metadata to publish. The blobs of the photo and of the variants get a reference,
+// then `save` must save them in the store: the image is committed only if it succeeds, so that it's never visible
+// without its blobs. `save` is called holding the write lock of the database, so RemoveImage can't release the blobs
+// meanwhile (see releaseBlobs).
+func (db *appdbimpl) InsertImageBlob(userID, blobKey, contentType, caption string, metadata PhotoMetadata,
+	variants []Variant, save func() error) (int64, error) {
+	var cameraModel interface{}
+	if metadata.CameraModel != "" {
+		cameraModel = metadata.CameraModel
 	}
-	return nil
+	var id int64
+	err := inTransaction(db.c, func(tx *sql.Tx) error {
+		if err := addBlobRef(tx, blobKey); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`INSERT INTO Images (user_id, created_at, blobkey, contenttype, taken_at, camera_model,
+			caption) VALUES (?, ?, ?, ?, ?, ?, ?)`, userID, globaltime.Now().UTC(), blobKey, contentType, metadata.TakenAt,
+			cameraModel, caption)
+		if err != nil {
+			return err
+		}
+		if id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		if err := setHashtags(tx, id, caption); err != nil {
+			return err
+		}
+		if err := setMentions(tx, captionMentions, id, caption); err != nil {
+			return err
+		}
+		for _, v := range variants {
+			if err := addBlobRef(tx, v.BlobKey); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO ImageVariants (image_id, width, height, blobkey, contenttype)
+				VALUES (?, ?, ?, ?, ?)`, id, v.Width, v.Height, v.BlobKey, v.ContentType)
+			if err != nil {
+				return err
+			}
+		}
+		return save()
+	})
+	return id, err
 }
 
-func (db *appdbimpl) AddComment(imageID int64, comment string) error {
-	// Retrieve the current comments for the image
-	var currentComments string
-	err := db.c.QueryRow("SELECT comments FROM Images WHERE id = ?", imageID).Scan(&currentComments)
-	if err != nil {
+// RemoveImage deletes the image, and drops the references to its blobs. The blobs left without references (i.e., not
+// shared with other images) are passed to `release`, which must remove them from the store, once the deletion is
+// committed (see releaseBlobs).
+func (db *appdbimpl) RemoveImage(imageID int64, release func(blobKeys []string)) error {
+	var unreferenced []string
+	err := inTransaction(db.c, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT blobkey FROM Images WHERE id = ? AND blobkey IS NOT NULL
+			UNION ALL SELECT blobkey FROM ImageVariants WHERE image_id = ?`, imageID, imageID)
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			keys = append(keys, key)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		// Likes, comments and variants are removed by ON DELETE CASCADE
+		if _, err := tx.Exec("DELETE FROM Images WHERE id = ?", imageID); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			res, err := tx.Exec("UPDATE Blobs SET refs = refs - 1 WHERE blobkey = ? AND refs > 1", key)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n > 0 {
+				continue
+			}
+			// That was the last reference
+			if _, err := tx.Exec("DELETE FROM Blobs WHERE blobkey = ?", key); err != nil {
+				return err
+			}
+			unreferenced = append(unreferenced, key)
+		}
+		return nil
+	})
+	if err != nil || len(unreferenced) == 0 {
 		return err
 	}
+	return db.releaseBlobs(unreferenced, release)
+}
 
-	// Concatenate the new comment with the current comments, separated by a special character
-	newComments := currentComments + "~" + comment
+// releaseBlobs passes to `release` the blobs that are still unreferenced. An upload of the same content may have taken
+// a new reference since they were dropped: those blobs are kept. The transaction starts with a write, which takes the
+// write lock of the database, so that uploads can't take new references until `release` returns. If it fails, the
+// blobs are only left in the store.
+func (db *appdbimpl) releaseBlobs(keys []string, release func(blobKeys []string)) error {
+	return inTransaction(db.c, func(tx *sql.Tx) error {
+		// Blobs always have references: it only takes the lock
+		if _, err := tx.Exec("DELETE FROM Blobs WHERE refs < 1"); err != nil {
+			return err
+		}
+		var unreferenced []string
+		for _, key := range keys {
+			var referenced bool
+			if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM Blobs WHERE blobkey = ?)", key).Scan(&referenced); err != nil {
+				return err
+			} else if !referenced {
+				unreferenced = append(unreferenced, key)
+			}
+		}
+		if len(unreferenced) > 0 {
+			release(unreferenced)
+		}
+		return nil
+	})
+}
 
-	// Update the comments for the image
-	_, err = db.c.Exec("UPDATE Images SET comments = ? WHERE id = ?", newComments, imageID)
-	if err != nil {
+// addBlobRef adds a reference to the blob, registering it if it's new
+func addBlobRef(tx *sql.Tx, blobKey string) error {
+	_, err := tx.Exec(`INSERT INTO Blobs (blobkey, refs) VALUES (?, 1)
+		ON CONFLICT (blobkey) DO UPDATE SET refs = refs + 1`, blobKey)
+	return err
+}
+
+// isBannedFromImage returns true if `userID` and the owner of the image banned each other, in either direction
+func (db *appdbimpl) isBannedFromImage(imageID int64, userID string) (bool, error) {
+	var banned bool
+	err := db.c.QueryRow(`SELECT EXISTS(SELECT 1 FROM Images JOIN Bans
+		ON (Bans.banner = Images.user_id AND Bans.banned = ?) OR (Bans.banner = ? AND Bans.banned = Images.user_id)
+		WHERE Images.id = ?)`, userID, userID, imageID).Scan(&banned)
+	return banned, err
+}
+
+// AddLike adds the like of `userID` to the image. It returns ErrBanned if the user and the owner of the image banned
+// each other.
+func (db *appdbimpl) AddLike(imageID int64, userID string) error {
+	if banned, err := db.isBannedFromImage(imageID, userID); err != nil {
 		return err
+	} else if banned {
+		return ErrBanned
 	}
+	_, err := db.c.Exec("INSERT OR IGNORE INTO Likes (image_id, user_id, created_at) VALUES (?, ?, ?)",
+		imageID, userID, globaltime.Now().UTC())
+	return err
+}
 
-	return nil
+func (db *appdbimpl) RemoveLike(imageID int64, userID string) error {
+	_, err := db.c.Exec("DELETE FROM Likes WHERE image_id = ? AND user_id = ?", imageID, userID)
+	return err
 }
 
-func (db *appdbimpl) RemoveComment(imageID int64, commentToRemove string) error {
-	// Retrieve the current comments for the image
-	var currentComments string
-	err := db.c.QueryRow("SELECT comments FROM Images WHERE id = ?", imageID).Scan(&currentComments)
+// likesOldestFirst is the order of lists of likes
+var likesOldestFirst = pageOrder{createdAt: "Likes.created_at", id: "Likes.user_id"}
+
+// GetLikes returns a page of the usernames of the users who like the image, in the order they liked it. Users who
+// banned `viewerID`, or were banned by `viewerID`, are left out.
+func (db *appdbimpl) GetLikes(imageID int64, viewerID string, page Page) ([]string, string, error) {
+	clause, args, err := likesOldestFirst.clause("Likes.image_id = ? AND "+notBannedEither("Likes.user_id"),
+		[]interface{}{imageID, viewerID, viewerID}, page)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
+	rows, err := db.c.Query(`SELECT Likes.user_id, Users.username, CAST(Likes.created_at AS TEXT)
+		FROM Likes JOIN Users ON Users.id = Likes.user_id`+clause, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
 
-	// Split the current comments string into individual comments
-	comments := strings.Split(currentComments, "~")
-
-	// Find and remove the comment to be removed
-	var updatedComments []string
-	for _, c := range comments {
-		if c != commentToRemove {
-			updatedComments = append(updatedComments, c)
+	var usernames = []string{}
+	var keys []cursor
+	for rows.Next() {
+		var userID, username, createdAt string
+		if err := rows.Scan(&userID, &username, &createdAt); err != nil {
+			return nil, "", err
 		}
+		usernames = append(usernames, username)
+		keys = append(keys, cursor{createdAt: createdAt, id: userID})
 	}
-
-	// Join the remaining comments into a single string
-	newComments := strings.Join(updatedComments, "~")
-
-	// Update the comments for the image
-	_, err = db.c.Exec("UPDATE Images SET comments = ? WHERE id = ?", newComments, imageID)
-	if err != nil {
-		return err
+	if err := rows.Err(); err != nil {
+		return nil, "", err
 	}
 
-	return nil
+	n, next := nextPage(keys, page)
+	return usernames[:n], next, nil
 }
 
-func (db *appdbimpl) GetImage(imageID int64) (Image, error) {
+// GetImage returns the image with the given ID, as seen by `viewerID`, or ErrImageNotFound. Images whose owner banned
+// the viewer are not found.
+func (db *appdbimpl) GetImage(imageID int64, viewerID string) (Image, error) {
 	// Query the Images table for the image with the given ID
-	var image Image
-	err := db.c.QueryRow("SELECT id, imageurl, username, likes, comments, created_at FROM Images WHERE id = ?", imageID).Scan(&image.ID, &image.ImageURL, &image.Username, &image.Likes, &image.Comments, &image.CreatedAt)
-	if err != nil {
+	image, _, err := scanImage(db.c.QueryRow("SELECT "+imageColumns+imagesFrom+" WHERE Images.id = ? AND "+imageVisible,
+		viewerID, imageID, viewerID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Image{}, ErrImageNotFound
+	} else if err != nil {
 		return Image{}, err
 	}
-	return image, nil
+	images := []Image{image}
+	if err := db.loadVariants(images); err != nil {
+		return Image{}, err
+	}
+	if err := db.loadImageMentions(images); err != nil {
+		return Image{}, err
+	}
+	return images[0], nil
 }
-
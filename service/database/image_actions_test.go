@@ -0,0 +1,51 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInsertImageBlobSave(t *testing.T) {
+	db := openTestDB(t)
+	appdb, err := New(db, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := appdb.AddUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variants := []Variant{{Width: 150, Height: 75, BlobKey: "variant", ContentType: "image/jpeg"}}
+	count := func(table string) int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// The image isn't inserted if its blobs can't be saved
+	errSave := errors.New("disk full")
+	_, err = appdb.InsertImageBlob(alice.ID, "photo", "image/png", "", PhotoMetadata{}, variants, func() error { return errSave })
+	if !errors.Is(err, errSave) {
+		t.Fatalf("expected the error of save, got %v", err)
+	}
+	if images, blobs := count("Images"), count("Blobs"); images != 0 || blobs != 0 {
+		t.Errorf("expected no images and no blob references, got %d and %d", images, blobs)
+	}
+
+	saved := false
+	id, err := appdb.InsertImageBlob(alice.ID, "photo", "image/png", "", PhotoMetadata{}, variants, func() error {
+		saved = true
+		return nil
+	})
+	if err != nil || !saved {
+		t.Fatalf("expected the blobs to be saved, got %v", err)
+	}
+	if image, err := appdb.GetImage(id, alice.ID); err != nil || image.BlobKey != "photo" || len(image.Variants) != 1 {
+		t.Errorf("expected the image with its variant, got %+v (%v)", image, err)
+	}
+	if blobs := count("Blobs"); blobs != 2 {
+		t.Errorf("expected 2 blob references, got %d", blobs)
+	}
+}
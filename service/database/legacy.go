@@ -0,0 +1,217 @@
+package database
+
+import (
+	"clean/service/globaltime"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// migrateLegacySchema converts a database created before versioning to the structure of the first migration:
+//   - the comma-joined `following` and `banned` columns of Users are moved into Follows and Bans
+//   - the `~`-joined `comments` column of Images is moved into Comments
+//   - the `likes` counter of Images is dropped, as those likes can't be attributed to any user
+//
+// Follows and bans pointing to users that don't exist are discarded. Owners of images that are missing from Users are
+// created, so no image is lost. The caller is responsible for the transaction.
+func migrateLegacySchema(tx *sql.Tx, logger logrus.FieldLogger) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	// Bring the old tables to their last known layout, so the copy below works regardless of the exact version
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS Images (id INTEGER PRIMARY KEY AUTOINCREMENT, imageurl TEXT UNIQUE, username TEXT,
+			likes INTEGER, comments TEXT, created_at DATETIME);`,
+		`CREATE TABLE IF NOT EXISTS Sessions (token TEXT PRIMARY KEY, username TEXT NOT NULL, created_at DATETIME);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"blobkey", "contenttype"} {
+		found, err := hasColumn(tx, "Images", column)
+		if err != nil {
+			return err
+		}
+		if !found {
+			if _, err := tx.Exec("ALTER TABLE Images ADD COLUMN " + column + " TEXT"); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Move the old tables away, so the new ones can be created with the same names
+	for _, stmt := range []string{
+		`ALTER TABLE Users RENAME TO Users_legacy;`,
+		`ALTER TABLE Images RENAME TO Images_legacy;`,
+		`ALTER TABLE Sessions RENAME TO Sessions_legacy;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	type legacyUser struct {
+		username  string
+		following string
+		banned    string
+	}
+	var users []legacyUser
+	rows, err := tx.Query("SELECT username, COALESCE(following, ''), COALESCE(banned, '') FROM Users_legacy")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var u legacyUser
+		if err := rows.Scan(&u.username, &u.following, &u.banned); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	if _, err := tx.Exec(migrations[0].up); err != nil {
+		return err
+	}
+
+	// Users, including owners of images that are missing
+	_, err = tx.Exec(`INSERT INTO Users (username)
+		SELECT username FROM Users_legacy
+		UNION SELECT username FROM Images_legacy WHERE username IS NOT NULL AND username <> ''`)
+	if err != nil {
+		return fmt.Errorf("copying users: %w", err)
+	}
+
+	// Images and their comments
+	_, err = tx.Exec(`INSERT INTO Images (id, imageurl, username, created_at, blobkey, contenttype)
+		SELECT id, imageurl, username, created_at, blobkey, contenttype FROM Images_legacy
+		WHERE username IS NOT NULL AND username <> ''`)
+	if err != nil {
+		return fmt.Errorf("copying images: %w", err)
+	}
+	comments, err := migrateLegacyComments(tx)
+	if err != nil {
+		return fmt.Errorf("copying comments: %w", err)
+	}
+
+	var droppedLikes int64
+	if err := tx.QueryRow("SELECT COALESCE(SUM(likes), 0) FROM Images_legacy").Scan(&droppedLikes); err != nil {
+		return err
+	}
+
+	// Follows and bans
+	var follows, bans int
+	now := globaltime.Now().UTC()
+	for _, u := range users {
+		for _, target := range splitLegacyList(u.following, ",") {
+			n, err := insertLegacyRelation(tx, "Follows", "follower", "followed", u.username, target, now)
+			if err != nil {
+				return fmt.Errorf("copying follows: %w", err)
+			}
+			follows += n
+		}
+		for _, target := range splitLegacyList(u.banned, ",") {
+			n, err := insertLegacyRelation(tx, "Bans", "banner", "banned", u.username, target, now)
+			if err != nil {
+				return fmt.Errorf("copying bans: %w", err)
+			}
+			bans += n
+		}
+	}
+
+	// Sessions
+	_, err = tx.Exec(`INSERT INTO Sessions (token, username, created_at)
+		SELECT token, username, created_at FROM Sessions_legacy WHERE username IN (SELECT username FROM Users)`)
+	if err != nil {
+		return fmt.Errorf("copying sessions: %w", err)
+	}
+
+	for _, stmt := range []string{
+		`DROP TABLE Users_legacy;`,
+		`DROP TABLE Images_legacy;`,
+		`DROP TABLE Sessions_legacy;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("Migrated %d users, %d follows, %d bans, %d comments (%d anonymous likes dropped)",
+		len(users), follows, bans, comments, droppedLikes)
+	return nil
+}
+
+// migrateLegacyComments splits the `~`-joined comments of the old Images table into rows of Comments. It returns the
+// number of comments inserted.
+func migrateLegacyComments(tx *sql.Tx) (int, error) {
+	type legacyImage struct {
+		id        int64
+		comments  string
+		createdAt interface{}
+	}
+	var images []legacyImage
+	rows, err := tx.Query(`SELECT id, COALESCE(comments, ''), created_at FROM Images_legacy
+		WHERE username IS NOT NULL AND username <> ''`)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var img legacyImage
+		if err := rows.Scan(&img.id, &img.comments, &img.createdAt); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	_ = rows.Close()
+
+	var count int
+	for _, img := range images {
+		for _, body := range splitLegacyList(img.comments, "~") {
+			_, err := tx.Exec("INSERT INTO Comments (image_id, body, created_at) VALUES (?, ?, ?)", img.id, body, img.createdAt)
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// insertLegacyRelation inserts a follow or a ban between two users, if the target user exists and it's not a relation
+// of a user with itself. It returns the number of rows inserted.
+func insertLegacyRelation(tx *sql.Tx, table, fromColumn, toColumn, from, to string, now time.Time) (int, error) {
+	if from == to {
+		return 0, nil
+	}
+	res, err := tx.Exec(fmt.Sprintf(`INSERT OR IGNORE INTO %s (%s, %s, created_at) SELECT ?, username, ? FROM Users
+		WHERE username = ?`, table, fromColumn, toColumn), from, now, to)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// splitLegacyList splits a list joined with `sep`, skipping empty items
+func splitLegacyList(joined, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(joined, sep) {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
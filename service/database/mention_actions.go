@@ -0,0 +1,250 @@
+package database
+
+import (
+	"clean/service/entities"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Mention is a user mentioned in a caption or a comment
+type Mention struct {
+	UserID string `json:"userId"`
+
+	// Username is the current username of the user, which is the one written in the text
+	Username string `json:"username"`
+
+	// Offset and Length locate the `@username` in the text, in UTF-16 code units (as JavaScript measures strings)
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+}
+
+// mentionSource describes a kind of text which can mention users
+type mentionSource struct {
+	// column is the column of Mentions referencing the text
+	column string
+
+	// table and text are the table of the texts and the column with the text
+	table string
+	text  string
+}
+
+var (
+	captionMentions = mentionSource{column: "image_id", table: "Images", text: "caption"}
+	commentMentions = mentionSource{column: "comment_id", table: "Comments", text: "body"}
+)
+
+// storedMention is a row of Mentions
+type storedMention struct {
+	rowID      int64
+	userID     string
+	start, end int
+}
+
+// setMentions replaces the mentions in the text `id` of `src` with the ones in `text`. Mentions of usernames that
+// don't exist are ignored (see mentionedUser).
+func setMentions(tx *sql.Tx, src mentionSource, id int64, text string) error {
+	if _, err := tx.Exec("DELETE FROM Mentions WHERE "+src.column+" = ?", id); err != nil {
+		return err
+	}
+	for _, m := range entities.Mentions(text) {
+		userID, err := mentionedUser(tx, m.Value)
+		if err != nil {
+			return err
+		} else if userID == "" {
+			continue
+		}
+		_, err = tx.Exec("INSERT INTO Mentions ("+src.column+", user_id, start_byte, end_byte) VALUES (?, ?, ?, ?)",
+			id, userID, m.Start, m.End)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mentionedUser returns the ID of the user mentioned as `@username`, or an empty string if there's none. Usernames are
+// matched exactly, or else ignoring the case of ASCII letters (as SQLite does), if only one user matches.
+func mentionedUser(tx *sql.Tx, username string) (string, error) {
+	var userID string
+	err := tx.QueryRow("SELECT id FROM Users WHERE username = ?", username).Scan(&userID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return userID, err
+	}
+
+	rows, err := tx.Query("SELECT id FROM Users WHERE username = ? COLLATE NOCASE LIMIT 2", username)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		if err := rows.Scan(&userID); err != nil {
+			return "", err
+		}
+		ids = append(ids, userID)
+	}
+	if err := rows.Err(); err != nil || len(ids) != 1 {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// loadMentions reads the mentions in the texts of `src`, by ID, with a single query. The texts are needed to convert
+// the offsets.
+func (db *appdbimpl) loadMentions(src mentionSource, texts map[int64]string) (map[int64][]Mention, error) {
+	mentions := make(map[int64][]Mention, len(texts))
+	if len(texts) == 0 {
+		return mentions, nil
+	}
+	args := make([]interface{}, 0, len(texts))
+	for id := range texts {
+		mentions[id] = []Mention{}
+		args = append(args, id)
+	}
+
+	rows, err := db.c.Query(`SELECT Mentions.`+src.column+`, Mentions.user_id, Users.username, Mentions.start_byte,
+		Mentions.end_byte FROM Mentions JOIN Users ON Users.id = Mentions.user_id
+		WHERE Mentions.`+src.column+` IN (?`+strings.Repeat(", ?", len(texts)-1)+`) ORDER BY Mentions.start_byte`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var m Mention
+		var start, end int
+		if err := rows.Scan(&id, &m.UserID, &m.Username, &start, &end); err != nil {
+			return nil, err
+		}
+		text := texts[id]
+		if start < 0 || start >= end || end > len(text) {
+			// The offsets always match the text, as it's rewritten along with them
+			continue
+		}
+		m.Offset = entities.UTF16Length(text[:start])
+		m.Length = entities.UTF16Length(text[start:end])
+		mentions[id] = append(mentions[id], m)
+	}
+	return mentions, rows.Err()
+}
+
+// loadImageMentions reads the mentions in the captions of the images
+func (db *appdbimpl) loadImageMentions(images []Image) error {
+	texts := make(map[int64]string, len(images))
+	for _, image := range images {
+		texts[image.ID] = image.Caption
+	}
+	mentions, err := db.loadMentions(captionMentions, texts)
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		images[i].Mentions = mentions[images[i].ID]
+	}
+	return nil
+}
+
+// loadCommentMentions reads the mentions in the comments
+func (db *appdbimpl) loadCommentMentions(comments []Comment) error {
+	texts := make(map[int64]string, len(comments))
+	for _, comment := range comments {
+		texts[comment.ID] = comment.Body
+	}
+	mentions, err := db.loadMentions(commentMentions, texts)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		comments[i].Mentions = mentions[comments[i].ID]
+	}
+	return nil
+}
+
+// renameMentions writes the new username of the user in the captions and comments mentioning them, and moves the
+// mentions that follow in the same texts. Texts can get longer than their limit: they are not checked again.
+func renameMentions(tx *sql.Tx, userID, username string) error {
+	for _, src := range []mentionSource{captionMentions, commentMentions} {
+		rows, err := tx.Query("SELECT DISTINCT "+src.column+" FROM Mentions WHERE user_id = ? AND "+src.column+
+			" IS NOT NULL", userID)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := renameMentionsIn(tx, src, id, userID, username); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renameMentionsIn rewrites the text `id` of `src` with the new username of the user (see renameMentions)
+func renameMentionsIn(tx *sql.Tx, src mentionSource, id int64, userID, username string) error {
+	var text string
+	err := tx.QueryRow("SELECT COALESCE("+src.text+", '') FROM "+src.table+" WHERE id = ?", id).Scan(&text)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT rowid, user_id, start_byte, end_byte FROM Mentions WHERE "+src.column+
+		" = ? ORDER BY start_byte", id)
+	if err != nil {
+		return err
+	}
+	var mentions []storedMention
+	for rows.Next() {
+		var m storedMention
+		if err := rows.Scan(&m.rowID, &m.userID, &m.start, &m.end); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		mentions = append(mentions, m)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range mentions {
+		if m.start < last || m.end > len(text) {
+			continue
+		}
+		b.WriteString(text[last:m.start])
+		start := b.Len()
+		if m.userID == userID {
+			b.WriteString("@" + username)
+		} else {
+			b.WriteString(text[m.start:m.end])
+		}
+		if _, err := tx.Exec("UPDATE Mentions SET start_byte = ?, end_byte = ? WHERE rowid = ?",
+			start, b.Len(), m.rowID); err != nil {
+			return err
+		}
+		last = m.end
+	}
+	b.WriteString(text[last:])
+
+	_, err = tx.Exec("UPDATE "+src.table+" SET "+src.text+" = ? WHERE id = ?", b.String(), id)
+	return err
+}
@@ -0,0 +1,316 @@
+package database
+
+import (
+	"clean/service/globaltime"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles contains the SQL scripts changing the database structure. Each migration has two files:
+// `NNNN_name.up.sql` applies it, and `NNNN_name.down.sql` reverts it. NNNN is the version reached by applying it.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database has been migrated by a newer version of the program
+var ErrSchemaTooNew = errors.New("database schema is newer than the latest known migration")
+
+// MigrationStatus describes a known migration, and whether it has been applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migration is a single step in the database structure history
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the embedded migrations, sorted by version. Versions must be consecutive, starting from 1.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		idx := strings.Index(base, "_")
+		if idx < 0 {
+			return nil, fmt.Errorf("malformed migration file name %s", name)
+		}
+		version, err := strconv.Atoi(base[:idx])
+		if err != nil {
+			return nil, fmt.Errorf("malformed migration version in %s: %w", name, err)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: base[idx+1:]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down scripts", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration version %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// LatestSchemaVersion returns the version reached by applying all the embedded migrations
+func LatestSchemaVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	return len(migrations), nil
+}
+
+// SchemaVersion returns the version of the database structure. Databases never migrated are at version 0.
+func SchemaVersion(db *sql.DB) (int, error) {
+	return schemaVersion(db)
+}
+
+func schemaVersion(db queryRower) (int, error) {
+	versioned, err := tableExists(db, "schema_version")
+	if err != nil || !versioned {
+		return 0, err
+	}
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// Migrations returns the list of known migrations, with their status in the database
+func Migrations(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]time.Time{}
+	versioned, err := tableExists(db, "schema_version")
+	if err != nil {
+		return nil, err
+	}
+	if versioned {
+		rows, err := db.Query("SELECT version, applied_at FROM schema_version")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version int
+			var appliedAt time.Time
+			if err := rows.Scan(&version, &appliedAt); err != nil {
+				return nil, err
+			}
+			applied[version] = appliedAt
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   m.version,
+			Name:      m.name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies all the pending migrations, each one in its own transaction. Databases created before the
+// introduction of versioning are adopted first. ErrSchemaTooNew is returned if the database has a version greater than
+// the latest known migration.
+func MigrateUp(db *sql.DB, logger logrus.FieldLogger) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if err := adoptUnversioned(db, logger); err != nil {
+		return fmt.Errorf("adopting unversioned database: %w", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("%w (database at version %d, latest known is %d)", ErrSchemaTooNew, current, len(migrations))
+	}
+
+	for _, m := range migrations[current:] {
+		logger.Infof("Applying migration %04d_%s", m.version, m.name)
+		err := inTransaction(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.up); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+				m.version, m.name, globaltime.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("applying migration %04d_%s: %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the latest applied migration in a transaction. It's a no-op if no migration has been applied.
+func MigrateDown(db *sql.DB, logger logrus.FieldLogger) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("%w (database at version %d, latest known is %d)", ErrSchemaTooNew, current, len(migrations))
+	}
+	if current == 0 {
+		logger.Infof("No migration to revert")
+		return nil
+	}
+
+	m := migrations[current-1]
+	logger.Infof("Reverting migration %04d_%s", m.version, m.name)
+	err = inTransaction(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(m.down); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM schema_version WHERE version = ?", m.version)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("reverting migration %04d_%s: %w", m.version, m.name, err)
+	}
+	return nil
+}
+
+// adoptUnversioned creates the schema_version table. If the database was created before versioning, it's converted
+// to the structure of the first migration (if needed) and marked as being at version 1.
+func adoptUnversioned(db *sql.DB, logger logrus.FieldLogger) error {
+	versioned, err := tableExists(db, "schema_version")
+	if err != nil || versioned {
+		return err
+	}
+
+	return inTransaction(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		);`)
+		if err != nil {
+			return err
+		}
+
+		existing, err := tableExists(tx, "Users")
+		if err != nil || !existing {
+			return err
+		}
+
+		// Databases created by older versions keep follows and bans as comma-joined strings inside Users
+		legacy, err := hasColumn(tx, "Users", "following")
+		if err != nil {
+			return err
+		}
+		if legacy {
+			logger.Infof("Old database structure found, migrating")
+			if err := migrateLegacySchema(tx, logger); err != nil {
+				return err
+			}
+		}
+
+		logger.Infof("Marking existing database as version 1")
+		_, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (1, 'initial', ?)", globaltime.Now().UTC())
+		return err
+	})
+}
+
+// inTransaction runs fn inside a transaction, committing it only if fn returns no error
+func inTransaction(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryRower is implemented by both sql.DB and sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// hasColumn checks whether the table exists and has the given column
+func hasColumn(db queryRower, table, column string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	return count > 0, err
+}
+
+// tableExists checks whether the table exists in the database
+func tableExists(db queryRower, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name = ?`, table).Scan(&count)
+	return count > 0, err
+}
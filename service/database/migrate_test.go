@@ -0,0 +1,288 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestDB opens an empty in-memory database, with foreign keys enabled
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	// Each connection to a plain ":memory:" database opens a different database: share the cache between connections
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := sql.Open("sqlite3", "file:"+name+"?mode=memory&cache=shared&_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// testLogger returns a logger discarding the messages
+func testLogger() logrus.FieldLogger {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return logger
+}
+
+// schema returns the definitions of the tables, indexes and triggers of the database, sorted. Tables rebuilt by a
+// migration are renamed, and SQLite quotes the new name in their definition: the quotes are removed.
+func schema(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT type || ' ' || name || ': ' || REPLACE(COALESCE(sql, ''), '"' || name || '"', name)
+		FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' AND name <> 'schema_version' ORDER BY type, name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var definitions []string
+	for rows.Next() {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			t.Fatal(err)
+		}
+		definitions = append(definitions, definition)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return definitions
+}
+
+// checkVersion fails the test if the database is not at the given version, or if its foreign keys are broken
+func checkVersion(t *testing.T, db *sql.DB, expected int) {
+	t.Helper()
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	} else if version != expected {
+		t.Fatalf("expected version %d, got %d", expected, version)
+	}
+	rows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		t.Fatalf("version %d: the foreign keys are broken", expected)
+	}
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reverting any number of migrations and applying them again gives the same structure
+	for reverted := 1; reverted <= latest; reverted++ {
+		reverted := reverted
+		t.Run(strconv.Itoa(reverted), func(t *testing.T) {
+			db := openTestDB(t)
+			if err := MigrateUp(db, testLogger()); err != nil {
+				t.Fatal(err)
+			}
+			checkVersion(t, db, latest)
+			expected := schema(t, db)
+
+			for i := 1; i <= reverted; i++ {
+				if err := MigrateDown(db, testLogger()); err != nil {
+					t.Fatalf("reverting to version %d: %v", latest-i, err)
+				}
+				checkVersion(t, db, latest-i)
+			}
+			if reverted == latest {
+				if tables := schema(t, db); len(tables) != 0 {
+					t.Errorf("expected an empty database after reverting all the migrations, got %q", tables)
+				}
+			}
+
+			if err := MigrateUp(db, testLogger()); err != nil {
+				t.Fatalf("applying %d migrations again: %v", reverted, err)
+			}
+			checkVersion(t, db, latest)
+			if got := schema(t, db); !reflect.DeepEqual(got, expected) {
+				t.Errorf("reverting %d migrations and applying them again changed the structure:\n%q\nexpected:\n%q",
+					reverted, got, expected)
+			}
+		})
+	}
+}
+
+func TestMigrateKeepsData(t *testing.T) {
+	db := openTestDB(t)
+	appdb, err := New(db, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := appdb.AddUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := appdb.AddUser("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := appdb.FollowUser(alice.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	carol, err := appdb.AddUser("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := appdb.BanUser(carol.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Before 0011_no_self_relations, users could follow and ban themselves: those relations are dropped
+	latest, _ := LatestSchemaVersion()
+	for version := latest; version > 10; version-- {
+		if err := MigrateDown(db, testLogger()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkVersion(t, db, 10)
+	for _, query := range []string{"INSERT INTO Follows (follower, followed) VALUES (?1, ?1)",
+		"INSERT INTO Bans (banner, banned) VALUES (?1, ?1)"} {
+		if _, err := db.Exec(query, bob.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := MigrateUp(db, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	checkVersion(t, db, latest)
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"SELECT COUNT(*) FROM Follows WHERE follower = ?1 AND followed = ?2", 1},
+		{"SELECT COUNT(*) FROM Bans WHERE banner = ?3 AND banned = ?1", 1},
+		{"SELECT COUNT(*) FROM Follows WHERE follower = followed", 0},
+		{"SELECT COUNT(*) FROM Bans WHERE banner = banned", 0},
+	}
+	for _, tt := range tests {
+		var count int
+		if err := db.QueryRow(tt.query, alice.ID, bob.ID, carol.ID).Scan(&count); err != nil {
+			t.Fatal(err)
+		} else if count != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.query, tt.expected, count)
+		}
+	}
+	if err := appdb.FollowUser(bob.ID, bob.ID); !errors.Is(err, ErrSelfRelation) {
+		t.Errorf("expected ErrSelfRelation, got %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO Bans (banner, banned) VALUES (?1, ?1)", alice.ID); err == nil {
+		t.Error("expected the CHECK constraint to refuse self bans")
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	db := openTestDB(t)
+
+	// Nothing to revert in an empty database
+	if err := MigrateDown(db, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	checkVersion(t, db, 0)
+
+	if err := MigrateUp(db, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	latest, _ := LatestSchemaVersion()
+	if _, err := db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', DATETIME())",
+		latest+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateUp(db, testLogger()); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("migrating up: expected ErrSchemaTooNew, got %v", err)
+	}
+	if err := MigrateDown(db, testLogger()); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("migrating down: expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	db := openTestDB(t)
+	if err := MigrateUp(db, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateDown(db, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := Migrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, _ := LatestSchemaVersion()
+	if len(statuses) != latest {
+		t.Fatalf("expected %d migrations, got %d", latest, len(statuses))
+	}
+	for i, s := range statuses {
+		applied := i < latest-1
+		if s.Version != i+1 || s.Name == "" || s.Applied != applied || s.AppliedAt.IsZero() == applied {
+			t.Errorf("unexpected status of migration %d: %+v", i+1, s)
+		}
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	db := openTestDB(t)
+
+	// Structure of the databases created before versioning, without the columns and tables added later
+	for _, stmt := range []string{
+		`CREATE TABLE Users (username TEXT PRIMARY KEY, following TEXT, banned TEXT);`,
+		`CREATE TABLE Images (id INTEGER PRIMARY KEY AUTOINCREMENT, imageurl TEXT UNIQUE, username TEXT, likes INTEGER,
+			comments TEXT, created_at DATETIME);`,
+		`INSERT INTO Users VALUES ('alice', 'bob, ghost,,alice', 'carol'), ('bob', NULL, ''), ('carol', 'alice', NULL);`,
+		`INSERT INTO Images (imageurl, username, likes, comments) VALUES ('https://example.com/1.jpg', 'alice', 5,
+			'nice~~ great '), ('https://example.com/2.jpg', 'dave', 1, NULL), ('https://example.com/3.jpg', '', 0, 'x');`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := MigrateUp(db, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	latest, _ := LatestSchemaVersion()
+	checkVersion(t, db, latest)
+
+	// Relations with missing users or with themselves are dropped, owners of images are created
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"SELECT COUNT(*) FROM Users", 4},
+		{"SELECT COUNT(*) FROM Images", 2},
+		{"SELECT COUNT(*) FROM Follows", 2},
+		{"SELECT COUNT(*) FROM Bans", 1},
+		{"SELECT COUNT(*) FROM Comments", 2},
+		{"SELECT COUNT(*) FROM Comments WHERE body IN ('nice', 'great')", 2},
+	}
+	for _, tt := range tests {
+		var count int
+		if err := db.QueryRow(tt.query).Scan(&count); err != nil {
+			t.Fatal(err)
+		} else if count != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.query, tt.expected, count)
+		}
+	}
+	for _, table := range []string{"Users_legacy", "Images_legacy", "Sessions_legacy"} {
+		if exists, err := tableExists(db, table); err != nil || exists {
+			t.Errorf("expected %s to be dropped (%v)", table, err)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+DROP TABLE Sessions;
+DROP TABLE Comments;
+DROP TABLE Likes;
+DROP TABLE Bans;
+DROP TABLE Follows;
+DROP TABLE Images;
+DROP TABLE Users;
@@ -0,0 +1,61 @@
+-- Initial structure: users, photos and the relations between them.
+-- Users are referenced by username: renames are propagated by ON UPDATE CASCADE.
+
+CREATE TABLE Users (
+    username TEXT PRIMARY KEY
+);
+
+CREATE TABLE Images (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    imageurl TEXT UNIQUE,
+    username TEXT NOT NULL REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME,
+    blobkey TEXT,
+    contenttype TEXT
+);
+
+CREATE INDEX images_username ON Images (username, created_at);
+
+CREATE TABLE Follows (
+    follower TEXT NOT NULL REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    followed TEXT NOT NULL REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (follower, followed)
+);
+
+CREATE INDEX follows_followed ON Follows (followed);
+
+CREATE TABLE Bans (
+    banner TEXT NOT NULL REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    banned TEXT NOT NULL REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (banner, banned)
+);
+
+CREATE INDEX bans_banned ON Bans (banned);
+
+CREATE TABLE Likes (
+    image_id INTEGER NOT NULL REFERENCES Images (id) ON DELETE CASCADE,
+    username TEXT NOT NULL REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (image_id, username)
+);
+
+CREATE INDEX likes_username ON Likes (username);
+
+CREATE TABLE Comments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_id INTEGER NOT NULL REFERENCES Images (id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at DATETIME
+);
+
+CREATE INDEX comments_image_id ON Comments (image_id, created_at);
+
+CREATE TABLE Sessions (
+    token TEXT PRIMARY KEY,
+    username TEXT NOT NULL REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME
+);
+
+CREATE INDEX sessions_username ON Sessions (username);
@@ -0,0 +1,16 @@
+-- SQLite can't drop a column used in a foreign key: rebuild the table without it.
+
+CREATE TABLE Comments_down (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_id INTEGER NOT NULL REFERENCES Images (id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at DATETIME
+);
+
+INSERT INTO Comments_down (id, image_id, body, created_at) SELECT id, image_id, body, created_at FROM Comments;
+
+DROP TABLE Comments;
+
+ALTER TABLE Comments_down RENAME TO Comments;
+
+CREATE INDEX comments_image_id ON Comments (image_id, created_at);
@@ -0,0 +1,3 @@
+-- Comments record their author. Comments migrated from the old `~`-joined column have no author (NULL).
+
+ALTER TABLE Comments ADD COLUMN username TEXT REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE;
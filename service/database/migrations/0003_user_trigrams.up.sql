@@ -0,0 +1,17 @@
+-- Trigrams of the usernames, used by the user search for fuzzy matching. Usernames are lowercased and padded with a
+-- space on both sides, so that trigrams at the start and at the end of the name weigh more.
+
+CREATE TABLE UserTrigrams (
+    username TEXT NOT NULL REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    trigram TEXT NOT NULL,
+    PRIMARY KEY (username, trigram)
+);
+
+CREATE INDEX usertrigrams_trigram ON UserTrigrams (trigram);
+
+WITH RECURSIVE padded (username, s, i) AS (
+    SELECT username, ' ' || lower(username) || ' ', 1 FROM Users
+    UNION ALL
+    SELECT username, s, i + 1 FROM padded WHERE i + 3 <= length(s)
+)
+INSERT OR IGNORE INTO UserTrigrams (username, trigram) SELECT username, substr(s, i, 3) FROM padded;
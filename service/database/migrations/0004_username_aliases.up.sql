@@ -0,0 +1,10 @@
+-- Old usernames of renamed users. Until `expires_at`, the old username still resolves to the user (e.g., in links) and
+-- can't be taken by other users. Chained renames are followed by ON UPDATE CASCADE.
+
+CREATE TABLE UsernameAliases (
+    alias TEXT PRIMARY KEY,
+    username TEXT NOT NULL REFERENCES Users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX usernamealiases_username ON UsernameAliases (username);
@@ -0,0 +1,132 @@
+-- Relations reference the username again, and the IDs of the users are lost. See the up migration for the order of the
+-- statements.
+
+CREATE TABLE Users_down (
+    username TEXT PRIMARY KEY
+);
+
+INSERT INTO Users_down (username) SELECT username FROM Users;
+
+CREATE TABLE Images_down (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    imageurl TEXT UNIQUE,
+    username TEXT NOT NULL REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME,
+    blobkey TEXT,
+    contenttype TEXT
+);
+
+INSERT INTO Images_down (id, imageurl, username, created_at, blobkey, contenttype)
+SELECT Images.id, Images.imageurl, Users.username, Images.created_at, Images.blobkey, Images.contenttype
+FROM Images JOIN Users ON Users.id = Images.user_id;
+
+CREATE TABLE Follows_down (
+    follower TEXT NOT NULL REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    followed TEXT NOT NULL REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (follower, followed)
+);
+
+INSERT INTO Follows_down (follower, followed, created_at)
+SELECT Follower.username, Followed.username, Follows.created_at
+FROM Follows
+JOIN Users AS Follower ON Follower.id = Follows.follower
+JOIN Users AS Followed ON Followed.id = Follows.followed;
+
+CREATE TABLE Bans_down (
+    banner TEXT NOT NULL REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    banned TEXT NOT NULL REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (banner, banned)
+);
+
+INSERT INTO Bans_down (banner, banned, created_at)
+SELECT Banner.username, Banned.username, Bans.created_at
+FROM Bans
+JOIN Users AS Banner ON Banner.id = Bans.banner
+JOIN Users AS Banned ON Banned.id = Bans.banned;
+
+CREATE TABLE Likes_down (
+    image_id INTEGER NOT NULL REFERENCES Images_down (id) ON DELETE CASCADE,
+    username TEXT NOT NULL REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (image_id, username)
+);
+
+INSERT INTO Likes_down (image_id, username, created_at)
+SELECT Likes.image_id, Users.username, Likes.created_at
+FROM Likes JOIN Users ON Users.id = Likes.user_id;
+
+CREATE TABLE Comments_down (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_id INTEGER NOT NULL REFERENCES Images_down (id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at DATETIME,
+    username TEXT REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+INSERT INTO Comments_down (id, image_id, body, created_at, username)
+SELECT Comments.id, Comments.image_id, Comments.body, Comments.created_at, Users.username
+FROM Comments LEFT JOIN Users ON Users.id = Comments.user_id;
+
+CREATE TABLE Sessions_down (
+    token TEXT PRIMARY KEY,
+    username TEXT NOT NULL REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at DATETIME
+);
+
+INSERT INTO Sessions_down (token, username, created_at)
+SELECT Sessions.token, Users.username, Sessions.created_at
+FROM Sessions JOIN Users ON Users.id = Sessions.user_id;
+
+CREATE TABLE UserTrigrams_down (
+    username TEXT NOT NULL REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    trigram TEXT NOT NULL,
+    PRIMARY KEY (username, trigram)
+);
+
+INSERT INTO UserTrigrams_down (username, trigram)
+SELECT Users.username, UserTrigrams.trigram
+FROM UserTrigrams JOIN Users ON Users.id = UserTrigrams.user_id;
+
+CREATE TABLE UsernameAliases_down (
+    alias TEXT PRIMARY KEY,
+    username TEXT NOT NULL REFERENCES Users_down (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    expires_at DATETIME NOT NULL
+);
+
+INSERT INTO UsernameAliases_down (alias, username, expires_at)
+SELECT UsernameAliases.alias, Users.username, UsernameAliases.expires_at
+FROM UsernameAliases JOIN Users ON Users.id = UsernameAliases.user_id;
+
+DELETE FROM sqlite_sequence WHERE name IN ('Images_down', 'Comments_down');
+INSERT INTO sqlite_sequence (name, seq) SELECT name || '_down', seq FROM sqlite_sequence WHERE name IN ('Images', 'Comments');
+
+DROP TABLE UsernameAliases;
+DROP TABLE UserTrigrams;
+DROP TABLE Sessions;
+DROP TABLE Comments;
+DROP TABLE Likes;
+DROP TABLE Bans;
+DROP TABLE Follows;
+DROP TABLE Images;
+DROP TABLE Users;
+
+ALTER TABLE Users_down RENAME TO Users;
+ALTER TABLE Images_down RENAME TO Images;
+ALTER TABLE Follows_down RENAME TO Follows;
+ALTER TABLE Bans_down RENAME TO Bans;
+ALTER TABLE Likes_down RENAME TO Likes;
+ALTER TABLE Comments_down RENAME TO Comments;
+ALTER TABLE Sessions_down RENAME TO Sessions;
+ALTER TABLE UserTrigrams_down RENAME TO UserTrigrams;
+ALTER TABLE UsernameAliases_down RENAME TO UsernameAliases;
+
+CREATE INDEX images_username ON Images (username, created_at);
+CREATE INDEX follows_followed ON Follows (followed);
+CREATE INDEX bans_banned ON Bans (banned);
+CREATE INDEX likes_username ON Likes (username);
+CREATE INDEX comments_image_id ON Comments (image_id, created_at);
+CREATE INDEX sessions_username ON Sessions (username);
+CREATE INDEX usertrigrams_trigram ON UserTrigrams (trigram);
+CREATE INDEX usernamealiases_username ON UsernameAliases (username);
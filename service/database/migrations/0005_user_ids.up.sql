@@ -0,0 +1,143 @@
+-- Users get an immutable ID (a random UUID), and all the relations reference it instead of the username, which is now
+-- a mutable attribute of the user. Existing users get a version 4 UUID generated here.
+--
+-- Every table referencing Users is rebuilt. Foreign keys are enforced during migrations: the new tables are filled
+-- first, then the old ones are dropped (children before parents, so nothing cascades) and the new ones renamed.
+
+CREATE TABLE Users_new (
+    id TEXT PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE
+);
+
+INSERT INTO Users_new (id, username)
+SELECT lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' || substr(lower(hex(randomblob(2))), 2)
+    || '-' || substr('89ab', 1 + abs(random() % 4), 1) || substr(lower(hex(randomblob(2))), 2)
+    || '-' || lower(hex(randomblob(6))), username
+FROM Users;
+
+CREATE TABLE Images_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    imageurl TEXT UNIQUE,
+    user_id TEXT NOT NULL REFERENCES Users_new (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    blobkey TEXT,
+    contenttype TEXT
+);
+
+INSERT INTO Images_new (id, imageurl, user_id, created_at, blobkey, contenttype)
+SELECT Images.id, Images.imageurl, Users_new.id, Images.created_at, Images.blobkey, Images.contenttype
+FROM Images JOIN Users_new ON Users_new.username = Images.username;
+
+CREATE TABLE Follows_new (
+    follower TEXT NOT NULL REFERENCES Users_new (id) ON DELETE CASCADE,
+    followed TEXT NOT NULL REFERENCES Users_new (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (follower, followed)
+);
+
+INSERT INTO Follows_new (follower, followed, created_at)
+SELECT Follower.id, Followed.id, Follows.created_at
+FROM Follows
+JOIN Users_new AS Follower ON Follower.username = Follows.follower
+JOIN Users_new AS Followed ON Followed.username = Follows.followed;
+
+CREATE TABLE Bans_new (
+    banner TEXT NOT NULL REFERENCES Users_new (id) ON DELETE CASCADE,
+    banned TEXT NOT NULL REFERENCES Users_new (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (banner, banned)
+);
+
+INSERT INTO Bans_new (banner, banned, created_at)
+SELECT Banner.id, Banned.id, Bans.created_at
+FROM Bans
+JOIN Users_new AS Banner ON Banner.username = Bans.banner
+JOIN Users_new AS Banned ON Banned.username = Bans.banned;
+
+CREATE TABLE Likes_new (
+    image_id INTEGER NOT NULL REFERENCES Images_new (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES Users_new (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (image_id, user_id)
+);
+
+INSERT INTO Likes_new (image_id, user_id, created_at)
+SELECT Likes.image_id, Users_new.id, Likes.created_at
+FROM Likes JOIN Users_new ON Users_new.username = Likes.username;
+
+-- Comments migrated from the old `~`-joined column still have no author (NULL)
+CREATE TABLE Comments_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_id INTEGER NOT NULL REFERENCES Images_new (id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at DATETIME,
+    user_id TEXT REFERENCES Users_new (id) ON DELETE CASCADE
+);
+
+INSERT INTO Comments_new (id, image_id, body, created_at, user_id)
+SELECT Comments.id, Comments.image_id, Comments.body, Comments.created_at, Users_new.id
+FROM Comments LEFT JOIN Users_new ON Users_new.username = Comments.username;
+
+CREATE TABLE Sessions_new (
+    token TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES Users_new (id) ON DELETE CASCADE,
+    created_at DATETIME
+);
+
+INSERT INTO Sessions_new (token, user_id, created_at)
+SELECT Sessions.token, Users_new.id, Sessions.created_at
+FROM Sessions JOIN Users_new ON Users_new.username = Sessions.username;
+
+CREATE TABLE UserTrigrams_new (
+    user_id TEXT NOT NULL REFERENCES Users_new (id) ON DELETE CASCADE,
+    trigram TEXT NOT NULL,
+    PRIMARY KEY (user_id, trigram)
+);
+
+INSERT INTO UserTrigrams_new (user_id, trigram)
+SELECT Users_new.id, UserTrigrams.trigram
+FROM UserTrigrams JOIN Users_new ON Users_new.username = UserTrigrams.username;
+
+-- Aliases now point to the user, not to its current username: chained renames don't need to update them
+CREATE TABLE UsernameAliases_new (
+    alias TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES Users_new (id) ON DELETE CASCADE,
+    expires_at DATETIME NOT NULL
+);
+
+INSERT INTO UsernameAliases_new (alias, user_id, expires_at)
+SELECT UsernameAliases.alias, Users_new.id, UsernameAliases.expires_at
+FROM UsernameAliases JOIN Users_new ON Users_new.username = UsernameAliases.username;
+
+-- Keep the AUTOINCREMENT counters, so the IDs of deleted photos and comments are not reused
+DELETE FROM sqlite_sequence WHERE name IN ('Images_new', 'Comments_new');
+INSERT INTO sqlite_sequence (name, seq) SELECT name || '_new', seq FROM sqlite_sequence WHERE name IN ('Images', 'Comments');
+
+DROP TABLE UsernameAliases;
+DROP TABLE UserTrigrams;
+DROP TABLE Sessions;
+DROP TABLE Comments;
+DROP TABLE Likes;
+DROP TABLE Bans;
+DROP TABLE Follows;
+DROP TABLE Images;
+DROP TABLE Users;
+
+ALTER TABLE Users_new RENAME TO Users;
+ALTER TABLE Images_new RENAME TO Images;
+ALTER TABLE Follows_new RENAME TO Follows;
+ALTER TABLE Bans_new RENAME TO Bans;
+ALTER TABLE Likes_new RENAME TO Likes;
+ALTER TABLE Comments_new RENAME TO Comments;
+ALTER TABLE Sessions_new RENAME TO Sessions;
+ALTER TABLE UserTrigrams_new RENAME TO UserTrigrams;
+ALTER TABLE UsernameAliases_new RENAME TO UsernameAliases;
+
+CREATE INDEX images_user_id ON Images (user_id, created_at);
+CREATE INDEX follows_followed ON Follows (followed);
+CREATE INDEX bans_banned ON Bans (banned);
+CREATE INDEX likes_user_id ON Likes (user_id);
+CREATE INDEX comments_image_id ON Comments (image_id, created_at);
+CREATE INDEX sessions_user_id ON Sessions (user_id);
+CREATE INDEX usertrigrams_trigram ON UserTrigrams (trigram);
+CREATE INDEX usernamealiases_user_id ON UsernameAliases (user_id);
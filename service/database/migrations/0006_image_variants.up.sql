@@ -0,0 +1,11 @@
+-- Resized copies of the uploaded photos (see the imaging package), saved in the blob store next to the original. Only
+-- the widths narrower than the original have a variant. Photos uploaded before variants were introduced have none.
+
+CREATE TABLE ImageVariants (
+    image_id INTEGER NOT NULL REFERENCES Images (id) ON DELETE CASCADE,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    blobkey TEXT NOT NULL,
+    contenttype TEXT NOT NULL,
+    PRIMARY KEY (image_id, width)
+);
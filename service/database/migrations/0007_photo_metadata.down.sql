@@ -0,0 +1,2 @@
+ALTER TABLE Images DROP COLUMN camera_model;
+ALTER TABLE Images DROP COLUMN taken_at;
@@ -0,0 +1,5 @@
+-- Metadata of the uploaded photos that the owner chose to publish (see the imaging package). The rest of the metadata
+-- is stripped from the stored photo. Both columns are NULL by default.
+
+ALTER TABLE Images ADD COLUMN taken_at DATETIME;
+ALTER TABLE Images ADD COLUMN camera_model TEXT;
@@ -0,0 +1,68 @@
+-- The UNIQUE constraint on imageurl is restored: reverting fails if several posts share a URL, delete them first.
+-- Blobs keep their content-addressed keys, which are valid keys in the store.
+
+CREATE TABLE Images_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    imageurl TEXT UNIQUE,
+    user_id TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    blobkey TEXT,
+    contenttype TEXT,
+    taken_at DATETIME,
+    camera_model TEXT
+);
+
+INSERT INTO Images_new (id, imageurl, user_id, created_at, blobkey, contenttype, taken_at, camera_model)
+SELECT id, imageurl, user_id, created_at, blobkey, contenttype, taken_at, camera_model
+FROM Images;
+
+CREATE TABLE Likes_new (
+    image_id INTEGER NOT NULL REFERENCES Images_new (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (image_id, user_id)
+);
+
+INSERT INTO Likes_new (image_id, user_id, created_at)
+SELECT image_id, user_id, created_at FROM Likes;
+
+CREATE TABLE Comments_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_id INTEGER NOT NULL REFERENCES Images_new (id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at DATETIME,
+    user_id TEXT REFERENCES Users (id) ON DELETE CASCADE
+);
+
+INSERT INTO Comments_new (id, image_id, body, created_at, user_id)
+SELECT id, image_id, body, created_at, user_id FROM Comments;
+
+CREATE TABLE ImageVariants_new (
+    image_id INTEGER NOT NULL REFERENCES Images_new (id) ON DELETE CASCADE,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    blobkey TEXT NOT NULL,
+    contenttype TEXT NOT NULL,
+    PRIMARY KEY (image_id, width)
+);
+
+INSERT INTO ImageVariants_new (image_id, width, height, blobkey, contenttype)
+SELECT image_id, width, height, blobkey, contenttype FROM ImageVariants;
+
+DELETE FROM sqlite_sequence WHERE name IN ('Images_new', 'Comments_new');
+INSERT INTO sqlite_sequence (name, seq) SELECT name || '_new', seq FROM sqlite_sequence WHERE name IN ('Images', 'Comments');
+
+DROP TABLE ImageVariants;
+DROP TABLE Comments;
+DROP TABLE Likes;
+DROP TABLE Images;
+DROP TABLE Blobs;
+
+ALTER TABLE Images_new RENAME TO Images;
+ALTER TABLE Likes_new RENAME TO Likes;
+ALTER TABLE Comments_new RENAME TO Comments;
+ALTER TABLE ImageVariants_new RENAME TO ImageVariants;
+
+CREATE INDEX images_user_id ON Images (user_id, created_at);
+CREATE INDEX likes_user_id ON Likes (user_id);
+CREATE INDEX comments_image_id ON Comments (image_id, created_at);
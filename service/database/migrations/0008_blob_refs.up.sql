@@ -0,0 +1,89 @@
+-- Photo blobs are shared by the posts with the same content: new blobs are addressed by the SHA-256 of their content,
+-- and Blobs counts the rows of Images and ImageVariants referencing each of them. A blob is removed from the store with
+-- its last reference. Blobs uploaded before keep their random keys, each with the references it has.
+--
+-- Posts by URL are not unique anymore: Images is rebuilt without the UNIQUE constraint on imageurl, and so are the
+-- tables referencing it. As in 0005, the new tables are filled first, then the old ones are dropped (children before
+-- parents, so nothing cascades) and the new ones renamed.
+
+CREATE TABLE Blobs (
+    blobkey TEXT PRIMARY KEY,
+    refs INTEGER NOT NULL CHECK (refs > 0)
+);
+
+INSERT INTO Blobs (blobkey, refs)
+SELECT blobkey, COUNT(*)
+FROM (
+    SELECT blobkey FROM Images WHERE blobkey != ''
+    UNION ALL
+    SELECT blobkey FROM ImageVariants
+)
+GROUP BY blobkey;
+
+CREATE TABLE Images_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    imageurl TEXT,
+    user_id TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    blobkey TEXT REFERENCES Blobs (blobkey),
+    contenttype TEXT,
+    taken_at DATETIME,
+    camera_model TEXT
+);
+
+INSERT INTO Images_new (id, imageurl, user_id, created_at, blobkey, contenttype, taken_at, camera_model)
+SELECT id, imageurl, user_id, created_at, NULLIF(blobkey, ''), contenttype, taken_at, camera_model
+FROM Images;
+
+CREATE TABLE Likes_new (
+    image_id INTEGER NOT NULL REFERENCES Images_new (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (image_id, user_id)
+);
+
+INSERT INTO Likes_new (image_id, user_id, created_at)
+SELECT image_id, user_id, created_at FROM Likes;
+
+CREATE TABLE Comments_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_id INTEGER NOT NULL REFERENCES Images_new (id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at DATETIME,
+    user_id TEXT REFERENCES Users (id) ON DELETE CASCADE
+);
+
+INSERT INTO Comments_new (id, image_id, body, created_at, user_id)
+SELECT id, image_id, body, created_at, user_id FROM Comments;
+
+CREATE TABLE ImageVariants_new (
+    image_id INTEGER NOT NULL REFERENCES Images_new (id) ON DELETE CASCADE,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    blobkey TEXT NOT NULL REFERENCES Blobs (blobkey),
+    contenttype TEXT NOT NULL,
+    PRIMARY KEY (image_id, width)
+);
+
+INSERT INTO ImageVariants_new (image_id, width, height, blobkey, contenttype)
+SELECT image_id, width, height, blobkey, contenttype FROM ImageVariants;
+
+-- Keep the AUTOINCREMENT counters, so the IDs of deleted photos and comments are not reused
+DELETE FROM sqlite_sequence WHERE name IN ('Images_new', 'Comments_new');
+INSERT INTO sqlite_sequence (name, seq) SELECT name || '_new', seq FROM sqlite_sequence WHERE name IN ('Images', 'Comments');
+
+DROP TABLE ImageVariants;
+DROP TABLE Comments;
+DROP TABLE Likes;
+DROP TABLE Images;
+
+ALTER TABLE Images_new RENAME TO Images;
+ALTER TABLE Likes_new RENAME TO Likes;
+ALTER TABLE Comments_new RENAME TO Comments;
+ALTER TABLE ImageVariants_new RENAME TO ImageVariants;
+
+CREATE INDEX images_user_id ON Images (user_id, created_at);
+CREATE INDEX images_blobkey ON Images (blobkey);
+CREATE INDEX likes_user_id ON Likes (user_id);
+CREATE INDEX comments_image_id ON Comments (image_id, created_at);
+CREATE INDEX imagevariants_blobkey ON ImageVariants (blobkey);
@@ -0,0 +1,2 @@
+DROP TABLE Hashtags;
+ALTER TABLE Images DROP COLUMN caption;
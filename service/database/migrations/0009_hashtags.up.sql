@@ -0,0 +1,12 @@
+-- Photos get an optional caption, editable by the owner. The hashtags of the caption (see the entities package) are
+-- indexed in Hashtags, normalized to lowercase, to browse the photos by hashtag.
+
+ALTER TABLE Images ADD COLUMN caption TEXT;
+
+CREATE TABLE Hashtags (
+    tag TEXT NOT NULL,
+    image_id INTEGER NOT NULL REFERENCES Images (id) ON DELETE CASCADE,
+    PRIMARY KEY (tag, image_id)
+);
+
+CREATE INDEX hashtags_image_id ON Hashtags (image_id);
@@ -0,0 +1,18 @@
+-- The @mentions of users in captions and comments (see the entities package) that name an existing user. Each row is
+-- a mention in either a caption (image_id) or a comment (comment_id), with the byte offsets of the `@username` in the
+-- text. The texts are rewritten when a mentioned user changes username, so the offsets always match.
+--
+-- Captions and comments written before this migration are not parsed: they have no mentions.
+
+CREATE TABLE Mentions (
+    image_id INTEGER REFERENCES Images (id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES Comments (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    start_byte INTEGER NOT NULL,
+    end_byte INTEGER NOT NULL,
+    CHECK ((image_id IS NULL) <> (comment_id IS NULL))
+);
+
+CREATE INDEX mentions_image_id ON Mentions (image_id);
+CREATE INDEX mentions_comment_id ON Mentions (comment_id);
+CREATE INDEX mentions_user_id ON Mentions (user_id);
@@ -0,0 +1,27 @@
+-- The relations dropped by the up migration are not restored
+
+CREATE TABLE Follows_new (
+    follower TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    followed TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (follower, followed)
+);
+
+INSERT INTO Follows_new (follower, followed, created_at) SELECT follower, followed, created_at FROM Follows;
+
+CREATE TABLE Bans_new (
+    banner TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    banned TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (banner, banned)
+);
+
+INSERT INTO Bans_new (banner, banned, created_at) SELECT banner, banned, created_at FROM Bans;
+
+DROP TABLE Follows;
+DROP TABLE Bans;
+ALTER TABLE Follows_new RENAME TO Follows;
+ALTER TABLE Bans_new RENAME TO Bans;
+
+CREATE INDEX follows_followed ON Follows (followed);
+CREATE INDEX bans_banned ON Bans (banned);
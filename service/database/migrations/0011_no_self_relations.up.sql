@@ -0,0 +1,32 @@
+-- Users can't follow or ban themselves. The relations of users with themselves, which the API accepted until now, are
+-- dropped. SQLite can't add constraints to existing tables: Follows and Bans are rebuilt.
+
+CREATE TABLE Follows_new (
+    follower TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    followed TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (follower, followed),
+    CHECK (follower <> followed)
+);
+
+INSERT INTO Follows_new (follower, followed, created_at)
+SELECT follower, followed, created_at FROM Follows WHERE follower <> followed;
+
+CREATE TABLE Bans_new (
+    banner TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    banned TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    PRIMARY KEY (banner, banned),
+    CHECK (banner <> banned)
+);
+
+INSERT INTO Bans_new (banner, banned, created_at)
+SELECT banner, banned, created_at FROM Bans WHERE banner <> banned;
+
+DROP TABLE Follows;
+DROP TABLE Bans;
+ALTER TABLE Follows_new RENAME TO Follows;
+ALTER TABLE Bans_new RENAME TO Bans;
+
+CREATE INDEX follows_followed ON Follows (followed);
+CREATE INDEX bans_banned ON Bans (banned);
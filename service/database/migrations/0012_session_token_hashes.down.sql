@@ -0,0 +1,12 @@
+-- The tokens can't be recovered from their hashes: the sessions are dropped
+
+CREATE TABLE Sessions_new (
+    token TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME
+);
+
+DROP TABLE Sessions;
+ALTER TABLE Sessions_new RENAME TO Sessions;
+
+CREATE INDEX sessions_user_id ON Sessions (user_id);
@@ -0,0 +1,15 @@
+-- Sessions store the SHA-256 hash of their token instead of the token, and expire. The existing tokens can't be hashed
+-- in SQL: they are dropped, and their users have to log in again.
+
+CREATE TABLE Sessions_new (
+    token_hash TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES Users (id) ON DELETE CASCADE,
+    created_at DATETIME,
+    expires_at DATETIME NOT NULL
+);
+
+DROP TABLE Sessions;
+ALTER TABLE Sessions_new RENAME TO Sessions;
+
+CREATE INDEX sessions_user_id ON Sessions (user_id);
+CREATE INDEX sessions_expires_at ON Sessions (expires_at);
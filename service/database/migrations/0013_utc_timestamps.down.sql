@@ -0,0 +1,2 @@
+-- The times converted to UTC are equivalent to the original ones: they are kept
+SELECT 1;
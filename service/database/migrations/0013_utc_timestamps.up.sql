@@ -0,0 +1,24 @@
+-- Creation times are stored in UTC, in the format of the SQLite driver ("2006-01-02 15:04:05.999999999-07:00"), so that
+-- comparing them as text, as pagination cursors do, gives their chronological order. Rows inserted before were stored
+-- with the offset of the server time zone, or by datetime('now') without offset (in UTC): they are converted, keeping
+-- the milliseconds only. Times that SQLite can't parse are left as they are.
+
+UPDATE Images SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at)
+    || RTRIM(RTRIM(SUBSTR(strftime('%f', created_at), 3), '0'), '.') || '+00:00'
+WHERE created_at NOT LIKE '%+00:00' AND strftime('%f', created_at) IS NOT NULL;
+
+UPDATE Comments SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at)
+    || RTRIM(RTRIM(SUBSTR(strftime('%f', created_at), 3), '0'), '.') || '+00:00'
+WHERE created_at NOT LIKE '%+00:00' AND strftime('%f', created_at) IS NOT NULL;
+
+UPDATE Likes SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at)
+    || RTRIM(RTRIM(SUBSTR(strftime('%f', created_at), 3), '0'), '.') || '+00:00'
+WHERE created_at NOT LIKE '%+00:00' AND strftime('%f', created_at) IS NOT NULL;
+
+UPDATE Follows SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at)
+    || RTRIM(RTRIM(SUBSTR(strftime('%f', created_at), 3), '0'), '.') || '+00:00'
+WHERE created_at NOT LIKE '%+00:00' AND strftime('%f', created_at) IS NOT NULL;
+
+UPDATE Bans SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at)
+    || RTRIM(RTRIM(SUBSTR(strftime('%f', created_at), 3), '0'), '.') || '+00:00'
+WHERE created_at NOT LIKE '%+00:00' AND strftime('%f', created_at) IS NOT NULL;
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page selects a page of a list: at most Limit items, starting after the position encoded in Cursor. An empty Cursor
+// selects the first page.
+//
+// Lists are ordered by creation time, then by ID, so pages are stable even if items are added while paginating. Methods
+// returning a page also return the cursor for the next page, which is empty if there are no more items.
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+// cursor is the decoded position of the last item of a page. createdAt is the creation time as stored by SQLite: it's
+// compared with the stored values directly, so the comparison is always consistent with ORDER BY. Creation times are
+// all stored in UTC (see 0013_utc_timestamps), so the text order is the chronological order.
+type cursor struct {
+	createdAt string
+	id        string
+}
+
+// encodeCursor returns the opaque cursor pointing after the item with the given creation time and ID
+func encodeCursor(createdAt, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id + "\n" + createdAt))
+}
+
+// decodeCursor decodes a cursor created by encodeCursor. The second return value is false for an empty cursor.
+func decodeCursor(s string) (cursor, bool, error) {
+	if s == "" {
+		return cursor{}, false, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, false, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "\n", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return cursor{}, false, ErrInvalidCursor
+	}
+	return cursor{createdAt: parts[1], id: parts[0]}, true, nil
+}
+
+// intID returns the ID of the cursor for lists of items with numeric IDs
+func (c cursor) intID() (int64, error) {
+	id, err := strconv.ParseInt(c.id, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
+
+// pageOrder describes the ordering of a paginated list
+type pageOrder struct {
+	// createdAt is the column with the creation time of the items
+	createdAt string
+
+	// id is the column with the unique ID of the items, used to break ties
+	id string
+
+	// numericID is true if the ID column is an integer
+	numericID bool
+
+	// desc is true for lists ordered newest first
+	desc bool
+}
+
+// clause returns the keyset condition, the ordering and the limit for a page of a list, to be appended to a query.
+// `where` is the filter of the list, with its arguments in `args`. One item more than the limit is fetched, to know if
+// there is a next page (see nextPage).
+func (o pageOrder) clause(where string, args []interface{}, page Page) (string, []interface{}, error) {
+	op, order := ">", "ASC"
+	if o.desc {
+		op, order = "<", "DESC"
+	}
+
+	c, ok, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return "", nil, err
+	}
+	if ok {
+		var id interface{} = c.id
+		if o.numericID {
+			if id, err = c.intID(); err != nil {
+				return "", nil, err
+			}
+		}
+		where += " AND (" + o.createdAt + ", " + o.id + ") " + op + " (?, ?)"
+		args = append(args, c.createdAt, id)
+	}
+
+	clause := " WHERE " + where + " ORDER BY " + o.createdAt + " " + order + ", " + o.id + " " + order + " LIMIT ?"
+	return clause, append(args, page.Limit+1), nil
+}
+
+// nextPage receives the positions of the items fetched by a query built with pageOrder.clause. It returns how many
+// items belong to the page, and the cursor for the next page (empty if there are no more items).
+func nextPage(keys []cursor, page Page) (int, string) {
+	if len(keys) <= page.Limit {
+		return len(keys), ""
+	}
+	last := keys[page.Limit-1]
+	return page.Limit, encodeCursor(last.createdAt, last.id)
+}
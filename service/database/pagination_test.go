@@ -0,0 +1,193 @@
+package database
+
+import (
+	"clean/service/globaltime"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		createdAt string
+		id        string
+	}{
+		{"2023-05-01 10:20:30.123456789+02:00", "42"},
+		{"2023-05-01T10:20:30Z", "c9f0f895-fb98-4b9b-9c4d-5f6a7c0e1b2d"},
+		// Rows without creation time, created before it was recorded
+		{"", "1"},
+		{"with\nnewline", "7"},
+	}
+	for _, tt := range tests {
+		c, ok, err := decodeCursor(encodeCursor(tt.createdAt, tt.id))
+		if err != nil || !ok || c.createdAt != tt.createdAt || c.id != tt.id {
+			t.Errorf("expected the cursor of %q, %q to round trip, got %+v, %v, %v", tt.createdAt, tt.id, c, ok, err)
+		}
+	}
+
+	if _, ok, err := decodeCursor(""); ok || err != nil {
+		t.Errorf("expected an empty cursor to select the first page, got %v, %v", ok, err)
+	}
+	for _, invalid := range []string{"!!!", "bm8gbmV3bGluZQ", "CjIwMjM", "NDI=", encodeCursor("", "")} {
+		if _, _, err := decodeCursor(invalid); err != ErrInvalidCursor {
+			t.Errorf("decodeCursor(%q): expected ErrInvalidCursor, got %v", invalid, err)
+		}
+	}
+}
+
+func TestCursorIntID(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected int64
+		valid    bool
+	}{
+		{"42", 42, true},
+		{"-1", -1, true},
+		{"9223372036854775807", 9223372036854775807, true},
+		{"9223372036854775808", 0, false},
+		{"4x", 0, false},
+		{"c9f0f895", 0, false},
+	}
+	for _, tt := range tests {
+		id, err := cursor{id: tt.id}.intID()
+		if id != tt.expected || (err == nil) != tt.valid {
+			t.Errorf("intID of %q = %d, %v; expected %d", tt.id, id, err, tt.expected)
+		}
+	}
+}
+
+func TestPageOrderClause(t *testing.T) {
+	order := pageOrder{createdAt: "Images.created_at", id: "Images.id", numericID: true, desc: true}
+	tests := []struct {
+		name     string
+		order    pageOrder
+		page     Page
+		clause   string
+		args     []interface{}
+		expected error
+	}{
+		{
+			"first page", order, Page{Limit: 10},
+			" WHERE Images.user_id = ? ORDER BY Images.created_at DESC, Images.id DESC LIMIT ?",
+			[]interface{}{"u", 11}, nil,
+		},
+		{
+			"next page", order, Page{Limit: 10, Cursor: encodeCursor("2023-05-01", "42")},
+			" WHERE Images.user_id = ? AND (Images.created_at, Images.id) < (?, ?) ORDER BY Images.created_at DESC, " +
+				"Images.id DESC LIMIT ?",
+			[]interface{}{"u", "2023-05-01", int64(42), 11}, nil,
+		},
+		{
+			"ascending", pageOrder{createdAt: "created_at", id: "follower"}, Page{Limit: 1,
+				Cursor: encodeCursor("2023-05-01", "abc")},
+			" WHERE Images.user_id = ? AND (created_at, follower) > (?, ?) ORDER BY created_at ASC, follower ASC LIMIT ?",
+			[]interface{}{"u", "2023-05-01", "abc", 2}, nil,
+		},
+		{"invalid cursor", order, Page{Limit: 10, Cursor: "!"}, "", nil, ErrInvalidCursor},
+		{"text ID for a numeric list", order, Page{Limit: 10, Cursor: encodeCursor("2023-05-01", "abc")}, "", nil,
+			ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		clause, args, err := tt.order.clause("Images.user_id = ?", []interface{}{"u"}, tt.page)
+		if err != tt.expected {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.expected, err)
+		} else if clause != tt.clause || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: got %q %v\nexpected %q %v", tt.name, clause, args, tt.clause, tt.args)
+		}
+	}
+}
+
+func TestNextPage(t *testing.T) {
+	keys := func(n int) []cursor {
+		var keys []cursor
+		for i := 1; i <= n; i++ {
+			keys = append(keys, cursor{createdAt: "2023-05-0" + strconv.Itoa(i), id: strconv.Itoa(i)})
+		}
+		return keys
+	}
+	tests := []struct {
+		fetched int
+		limit   int
+		count   int
+		next    string
+	}{
+		{0, 3, 0, ""},
+		{2, 3, 2, ""},
+		{3, 3, 3, ""},
+		// One more than the limit: there's a next page, after the last item of this one
+		{4, 3, 3, encodeCursor("2023-05-03", "3")},
+	}
+	for _, tt := range tests {
+		count, next := nextPage(keys(tt.fetched), Page{Limit: tt.limit})
+		if count != tt.count || next != tt.next {
+			t.Errorf("%d items fetched for %d: expected %d and %q, got %d and %q", tt.fetched, tt.limit, tt.count,
+				tt.next, count, next)
+		}
+	}
+}
+
+func TestCursorMixedOffsets(t *testing.T) {
+	db := openTestDB(t)
+	appdb, err := New(db, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := appdb.AddUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Before 0013_utc_timestamps, photos were stored with the offset of the server, which changes with DST, or without
+	// offset by datetime('now'). Ordered as text, 03:50-04:00 would come before 06:45.
+	latest, _ := LatestSchemaVersion()
+	for version := latest; version > 12; version-- {
+		if err := MigrateDown(db, testLogger()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, createdAt := range []string{
+		"2024-03-10 01:30:00-05:00",
+		"2024-03-10 06:45:00",
+		"2024-03-10 03:50:00.123456789-04:00",
+		"2024-03-10 09:00:00.5+01:00",
+		"2024-03-10 08:30:00+00:00",
+	} {
+		if _, err := db.Exec("INSERT INTO Images (imageurl, user_id, created_at, caption) VALUES (?, ?, ?, '')",
+			"https://example.com/a.png", alice.ID, createdAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := MigrateUp(db, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+
+	// New photos are stored in UTC, whatever the time zone of the server
+	globaltime.FixedTime = time.Date(2024, 3, 10, 18, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+	if _, err := appdb.InsertImage("https://example.com/b.png", alice.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Whatever the page size, each photo is listed once, newest first
+	for limit := 1; limit <= 6; limit++ {
+		var ids []int64
+		page := Page{Limit: limit}
+		for {
+			images, next, err := appdb.GetUserPhotos(alice.ID, alice.ID, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, image := range images {
+				ids = append(ids, image.ID)
+			}
+			if next == "" {
+				break
+			}
+			page.Cursor = next
+		}
+		if expected := []int64{6, 5, 4, 3, 2, 1}; !reflect.DeepEqual(ids, expected) {
+			t.Errorf("pages of %d: expected %v, got %v", limit, expected, ids)
+		}
+	}
+}
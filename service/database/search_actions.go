@@ -0,0 +1,111 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// insertTrigrams inserts the trigrams of the username of a user (the placeholder is the ID) in UserTrigrams. It must
+// match the trigrams computed by the migration creating the table.
+const insertTrigrams = `WITH RECURSIVE padded (user_id, s, i) AS (
+	SELECT id, ' ' || lower(username) || ' ', 1 FROM Users WHERE id = ?
+	UNION ALL
+	SELECT user_id, s, i + 1 FROM padded WHERE i + 3 <= length(s)
+)
+INSERT OR IGNORE INTO UserTrigrams (user_id, trigram) SELECT user_id, substr(s, i, 3) FROM padded`
+
+// searchUsers ranks the users matching the query (the first three placeholders are the query itself, the LIKE pattern
+// for usernames starting with it and the pattern for usernames containing it). The rank is 3000 for the exact match,
+// 2000 for usernames starting with the query, 1000 for usernames containing it, plus the number of trigrams shared
+// with the query. Usernames sharing less than a third of the trigrams of the query, and not containing it, don't match.
+const searchUsers = `WITH RECURSIVE
+	query (s) AS (SELECT ' ' || lower(?1) || ' '),
+	query_trigrams (i, trigram) AS (
+		SELECT 1, substr(s, 1, 3) FROM query
+		UNION ALL
+		SELECT i + 1, substr((SELECT s FROM query), i + 1, 3) FROM query_trigrams
+		WHERE i + 3 <= length((SELECT s FROM query))
+	),
+	matches (user_id, shared) AS (
+		SELECT user_id, COUNT(*) FROM UserTrigrams
+		WHERE trigram IN (SELECT trigram FROM query_trigrams) GROUP BY user_id
+	),
+	ranked (id, username, rank) AS (
+		SELECT Users.id, Users.username,
+			CASE WHEN lower(Users.username) = lower(?1) THEN 3000
+				WHEN Users.username LIKE ?2 ESCAPE '\' THEN 2000
+				WHEN Users.username LIKE ?3 ESCAPE '\' THEN 1000
+				ELSE 0 END + COALESCE(matches.shared, 0)
+		FROM Users LEFT JOIN matches ON matches.user_id = Users.id
+		WHERE Users.username LIKE ?3 ESCAPE '\'
+			OR matches.shared * 3 >= (SELECT COUNT(DISTINCT trigram) FROM query_trigrams)
+	)
+SELECT id, username, rank, EXISTS(SELECT 1 FROM Follows WHERE follower = ?4 AND followed = ranked.id)
+FROM ranked
+WHERE NOT EXISTS(SELECT 1 FROM Bans WHERE (banner = ranked.id AND banned = ?4)
+	OR (banner = ?4 AND banned = ranked.id))`
+
+// SearchUsers returns a page of the users whose username matches `query`, best matches first, as seen by `viewerID`.
+// Usernames match if they contain the query, or if they are similar to it (sharing enough trigrams). Users who banned
+// the viewer, or were banned by the viewer, are left out.
+//
+// The position of a user in the results is its rank and its username: cursors for the next page are encoded like the
+// ones of other lists, with the rank in place of the creation time.
+func (db *appdbimpl) SearchUsers(query, viewerID string, page Page) ([]UserSummary, string, error) {
+	pattern := escapeLike(query)
+	args := []interface{}{query, pattern + "%", "%" + pattern + "%", viewerID}
+
+	where := ""
+	c, ok, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if ok {
+		rank, err := strconv.Atoi(c.createdAt)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		where = " AND (rank < ?5 OR (rank = ?5 AND username > ?6))"
+		args = append(args, rank, c.id)
+	}
+
+	rows, err := db.c.Query(searchUsers+where+" ORDER BY rank DESC, username ASC LIMIT "+strconv.Itoa(page.Limit+1),
+		args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var users = []UserSummary{}
+	var keys []cursor
+	for rows.Next() {
+		var user UserSummary
+		var rank int
+		if err := rows.Scan(&user.ID, &user.Username, &rank, &user.IsFollowing); err != nil {
+			return nil, "", err
+		}
+		users = append(users, user)
+		keys = append(keys, cursor{createdAt: strconv.Itoa(rank), id: user.Username})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	n, next := nextPage(keys, page)
+	return users[:n], next, nil
+}
+
+// updateTrigrams replaces the trigrams of the username of `userID` in UserTrigrams
+func updateTrigrams(tx *sql.Tx, userID string) error {
+	if _, err := tx.Exec("DELETE FROM UserTrigrams WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(insertTrigrams, userID)
+	return err
+}
+
+// escapeLike escapes the wildcards of LIKE patterns in `s`, using `\` as escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"clean/service/globaltime"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned when a session token is not known to the database, or when its session expired
+var ErrSessionNotFound = errors.New("session not found")
+
+// hashToken returns the SHA-256 hash of the session token, as stored in the database. Tokens are random, so they don't
+// need a salt; storing only the hash means that a leaked database doesn't give access to the accounts.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession stores a new session token for the given user, valid for `lifetime`. The expired sessions are deleted.
+func (db *appdbimpl) CreateSession(token, userID string, lifetime time.Duration) error {
+	now := globaltime.Now().UTC()
+	if _, err := db.c.Exec("DELETE FROM Sessions WHERE expires_at <= ?", now); err != nil {
+		return err
+	}
+	_, err := db.c.Exec("INSERT INTO Sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(token), userID, now, now.Add(lifetime))
+	return err
+}
+
+// GetSessionUser returns the user owning the session token, or ErrSessionNotFound if the token is unknown or expired
+func (db *appdbimpl) GetSessionUser(token string) (User, error) {
+	var user User
+	err := db.c.QueryRow(`SELECT Users.id, Users.username FROM Sessions JOIN Users ON Users.id = Sessions.user_id
+		WHERE token_hash = ? AND expires_at > ?`, hashToken(token), globaltime.Now().UTC()).Scan(&user.ID, &user.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrSessionNotFound
+	}
+	return user, err
+}
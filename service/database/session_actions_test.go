@@ -0,0 +1,69 @@
+package database
+
+import (
+	"clean/service/globaltime"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	db := openTestDB(t)
+	appdb, err := New(db, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := appdb.AddUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	globaltime.FixedTime = start
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+	if err := appdb.CreateSession("secret-token", alice.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the hash of the token is stored
+	var stored string
+	if err := db.QueryRow("SELECT token_hash FROM Sessions").Scan(&stored); err != nil {
+		t.Fatal(err)
+	} else if stored == "secret-token" || stored != hashToken("secret-token") {
+		t.Errorf("expected the hash of the token to be stored, got %q", stored)
+	}
+
+	tests := []struct {
+		token string
+		at    time.Time
+		found bool
+	}{
+		{"secret-token", start, true},
+		{"secret-token", start.Add(59 * time.Minute), true},
+		{"secret-token", start.Add(time.Hour), false},
+		{hashToken("secret-token"), start, false},
+		{"other-token", start, false},
+	}
+	for _, tt := range tests {
+		globaltime.FixedTime = tt.at
+		user, err := appdb.GetSessionUser(tt.token)
+		switch {
+		case tt.found && (err != nil || user.ID != alice.ID):
+			t.Errorf("%q at %v: expected alice, got %+v (%v)", tt.token, tt.at, user, err)
+		case !tt.found && !errors.Is(err, ErrSessionNotFound):
+			t.Errorf("%q at %v: expected ErrSessionNotFound, got %+v (%v)", tt.token, tt.at, user, err)
+		}
+	}
+
+	// Expired sessions are deleted when a new one is created
+	globaltime.FixedTime = start.Add(2 * time.Hour)
+	if err := appdb.CreateSession("new-token", alice.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Sessions").Scan(&count); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Errorf("expected the expired session to be deleted, got %d sessions", count)
+	}
+}
@@ -1,249 +1,375 @@
 package database
 
 import (
+	"clean/service/globaltime"
 	"database/sql"
-	"strings"
+	"errors"
 	"fmt"
+	"github.com/gofrs/uuid"
+	"time"
 )
 
-type User struct {
-	Username  string
-	Following string
-	Banned    string
-}
+// ErrUserNotFound is returned when a user with the given ID or username does not exist
+var ErrUserNotFound = errors.New("user not found")
 
+// ErrUsernameTaken is returned when a username is already used by another user, or it's still an alias of another user
+// after a rename
+var ErrUsernameTaken = errors.New("username already taken")
 
-func (db *appdbimpl) CheckUsername(username string) (bool, error) {
-	var exists bool
-	query := "SELECT EXISTS(SELECT 1 FROM Users WHERE username = ?)"
-	err := db.c.QueryRow(query, username).Scan(&exists)
-	return exists, err
-}
+// ErrBanned is returned when an interaction between two users is refused because one of them banned the other
+var ErrBanned = errors.New("one of the users banned the other")
 
-func (db *appdbimpl) AddUser(username string) error {
-	_, err := db.c.Exec("INSERT INTO Users (username) VALUES (?)", username)
-	return err
+// ErrSelfRelation is returned when a user tries to follow or ban themselves
+var ErrSelfRelation = errors.New("users can't follow or ban themselves")
+
+// User identifies a user. The ID is assigned at signup and never changes, while the username can be changed by the
+// user.
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
 }
 
-func (db *appdbimpl) UpdateUsername(oldUsername, newUsername string) error {
-	tx, err := db.c.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+// Profile is the public profile of a user, as seen by a viewer
+type Profile struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Followers int    `json:"followersCount"`
+	Following int    `json:"followingCount"`
+	Photos    int    `json:"photosCount"`
 
-	// Step 1: Update the username in the Users table
-	_, err = tx.Exec("UPDATE Users SET username = ? WHERE username = ?", newUsername, oldUsername)
-	if err != nil {
-		return err
-	}
+	// IsFollowing is true if the viewer follows the user
+	IsFollowing bool `json:"isFollowing"`
 
-	// Step 2: Update the username in the Images table
-	_, _ = tx.Exec("UPDATE Images SET username = ? WHERE username = ?", newUsername, oldUsername)
-	if err != nil {
-		return err
-	}
+	// IsFollowedBy is true if the user follows the viewer
+	IsFollowedBy bool `json:"isFollowedBy"`
 
-	// Commit the transaction
-	err = tx.Commit()
-	if err != nil {
-		return err
-	}
-	return nil
+	// IsBanned is true if the viewer banned the user
+	IsBanned bool `json:"isBanned"`
+
+	// Banned is the list of the usernames of the users banned by the user. It's private, and it's only set when the
+	// viewer is the user.
+	Banned []string `json:"banned,omitempty"`
 }
 
-func (db *appdbimpl) GetUser(username string) (User, error) {
+// GetUser returns the user with the given ID or current username, or ErrUserNotFound. IDs and usernames can't be
+// confused: IDs are longer than the longest username.
+func (db *appdbimpl) GetUser(idOrUsername string) (User, error) {
 	var user User
+	err := db.c.QueryRow("SELECT id, username FROM Users WHERE id = ? OR username = ?", idOrUsername, idOrUsername).
+		Scan(&user.ID, &user.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	return user, err
+}
 
-	// Execute the SELECT query to retrieve user data
-	var following sql.NullString
-	var banned sql.NullString
-	err := db.c.QueryRow("SELECT username, following, banned FROM Users WHERE username = ?", username).
-		Scan(&user.Username, &following, &banned)
+// AddUser creates a user with a new ID, or returns ErrUsernameTaken
+func (db *appdbimpl) AddUser(username string) (User, error) {
+	id, err := uuid.NewV4()
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// User not found, return an empty user and a nil error
-			return User{}, fmt.Errorf("user not found")
+		return User{}, fmt.Errorf("generating the user ID: %w", err)
+	}
+	user := User{ID: id.String(), Username: username}
+	err = inTransaction(db.c, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM Users WHERE username = ?)", username).Scan(&exists); err != nil {
+			return err
+		} else if exists {
+			return ErrUsernameTaken
+		}
+		if taken, err := aliasTaken(tx, username, ""); err != nil {
+			return err
+		} else if taken {
+			return ErrUsernameTaken
 		}
-		// Other error occurred, return the error
+		if _, err := tx.Exec("INSERT INTO Users (id, username) VALUES (?, ?)", user.ID, username); err != nil {
+			return err
+		}
+		return updateTrigrams(tx, user.ID)
+	})
+	if err != nil {
 		return User{}, err
 	}
+	return user, nil
+}
 
-	// Set the 'Following' field of the user struct
-	if following.Valid {
-		user.Following = following.String
-	} else {
-		user.Following = "" // or any default value you want to use
-	}
+// UpdateUsername renames the user, or returns ErrUserNotFound or ErrUsernameTaken. If `aliasFor` is positive, the old
+// username keeps resolving to the user (see ResolveUsername) for that long, and other users can't take it.
+func (db *appdbimpl) UpdateUsername(userID, newUsername string, aliasFor time.Duration) error {
+	// Relations reference the ID of the user: only the trigrams are computed again, and the texts mentioning the user
+	// are rewritten
+	return inTransaction(db.c, func(tx *sql.Tx) error {
+		var oldUsername string
+		err := tx.QueryRow("SELECT username FROM Users WHERE id = ?", userID).Scan(&oldUsername)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		} else if err != nil {
+			return err
+		}
+		if oldUsername == newUsername {
+			return nil
+		}
 
-	// Set the 'Banned' field of the user struct
-	if banned.Valid {
-		user.Banned = banned.String
-	} else {
-		user.Banned = "" // or any default value you want to use
-	}
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM Users WHERE username = ?)", newUsername).Scan(&exists); err != nil {
+			return err
+		} else if exists {
+			return ErrUsernameTaken
+		}
+		if taken, err := aliasTaken(tx, newUsername, userID); err != nil {
+			return err
+		} else if taken {
+			return ErrUsernameTaken
+		}
 
-	return user, nil
+		// Users can take back their own old usernames
+		if _, err := tx.Exec("DELETE FROM UsernameAliases WHERE alias = ?", newUsername); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE Users SET username = ? WHERE id = ?", newUsername, userID); err != nil {
+			return err
+		}
+		if aliasFor > 0 {
+			_, err := tx.Exec("INSERT OR REPLACE INTO UsernameAliases (alias, user_id, expires_at) VALUES (?, ?, ?)",
+				oldUsername, userID, globaltime.Now().UTC().Add(aliasFor))
+			if err != nil {
+				return err
+			}
+		}
+		if err := renameMentions(tx, userID, newUsername); err != nil {
+			return err
+		}
+		return updateTrigrams(tx, userID)
+	})
 }
 
-func (db *appdbimpl) FollowUsername(username, followingusername string) error {
-	// Check if the user exists
-	var count int
-	err := db.c.QueryRow("SELECT COUNT(*) FROM Users WHERE username = ?", username).Scan(&count)
-	if err != nil {
-		return err
-	}
-	if count == 0 {
-		return fmt.Errorf("user %s not found", username)
+// aliasTaken checks whether `alias` is the old username of a user other than `ownerID`, and it's not expired yet.
+// Expired aliases are deleted.
+func aliasTaken(tx *sql.Tx, alias, ownerID string) (bool, error) {
+	if _, err := tx.Exec("DELETE FROM UsernameAliases WHERE expires_at <= ?", globaltime.Now().UTC()); err != nil {
+		return false, err
 	}
+	var taken bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM UsernameAliases WHERE alias = ? AND user_id != ?)",
+		alias, ownerID).Scan(&taken)
+	return taken, err
+}
 
-	// Fetch the current following string
-	var following string
-	err = db.c.QueryRow("SELECT following FROM Users WHERE username = ?", username).Scan(&following)
-	if err != nil {
-		return err
+// ResolveUsername returns the current username of the user with the given username, or with the given old username
+// while its alias is not expired. It returns ErrUserNotFound if there's no such user.
+func (db *appdbimpl) ResolveUsername(username string) (string, error) {
+	var current string
+	err := db.c.QueryRow(`SELECT username FROM Users WHERE username = ?
+		UNION ALL
+		SELECT Users.username FROM UsernameAliases JOIN Users ON Users.id = UsernameAliases.user_id
+		WHERE alias = ? AND expires_at > ?
+		LIMIT 1`, username, username, globaltime.Now().UTC()).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
 	}
+	return current, err
+}
 
-	// Append the new followingusername to the existing string (comma-separated)
-	if following != "" {
-		following += ","
-	}
-	following += followingusername
-	// Update the 'following' column for the user
-	_, err = db.c.Exec("UPDATE Users SET following = ? WHERE username = ?", following, username)
+// GetProfile returns the profile of the user `userID` as seen by `viewerID`, or ErrUserNotFound
+func (db *appdbimpl) GetProfile(userID, viewerID string) (Profile, error) {
+	var profile Profile
+	err := db.c.QueryRow(`SELECT id, username,
+		(SELECT COUNT(*) FROM Follows WHERE followed = Users.id),
+		(SELECT COUNT(*) FROM Follows WHERE follower = Users.id),
+		(SELECT COUNT(*) FROM Images WHERE Images.user_id = Users.id),
+		EXISTS(SELECT 1 FROM Follows WHERE follower = ? AND followed = Users.id),
+		EXISTS(SELECT 1 FROM Follows WHERE follower = Users.id AND followed = ?),
+		EXISTS(SELECT 1 FROM Bans WHERE banner = ? AND banned = Users.id)
+		FROM Users WHERE id = ?`, viewerID, viewerID, viewerID, userID).
+		Scan(&profile.ID, &profile.Username, &profile.Followers, &profile.Following, &profile.Photos,
+			&profile.IsFollowing, &profile.IsFollowedBy, &profile.IsBanned)
 	if err != nil {
-		return err
+		if errors.Is(err, sql.ErrNoRows) {
+			return Profile{}, ErrUserNotFound
+		}
+		return Profile{}, err
 	}
 
-	return nil
+	if viewerID == userID {
+		profile.Banned, err = db.listUsernames(`SELECT Users.username FROM Bans JOIN Users ON Users.id = Bans.banned
+			WHERE Bans.banner = ? ORDER BY Users.username`, userID)
+		if err != nil {
+			return Profile{}, err
+		}
+	}
+	return profile, nil
 }
 
-func (db *appdbimpl) UnfollowUsername(username, unfollowingusername string) error {
-	// Check if the user exists
-	var count int
-	err := db.c.QueryRow("SELECT COUNT(*) FROM Users WHERE username = ?", username).Scan(&count)
+// listUsernames runs a query returning a single column of usernames. It never returns a nil slice.
+func (db *appdbimpl) listUsernames(query string, args ...interface{}) ([]string, error) {
+	rows, err := db.c.Query(query, args...)
 	if err != nil {
-		return err
-	}
-	if count == 0 {
-		return fmt.Errorf("user %s not found", username)
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Fetch the current following string
-	var following string
-	err = db.c.QueryRow("SELECT following FROM Users WHERE username = ?", username).Scan(&following)
-	if err != nil {
-		return err
+	var usernames = []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
 	}
+	return usernames, rows.Err()
+}
 
-	// Split the following string into a slice of usernames
-	followingList := strings.Split(following, ",")
-
-	// Find and remove the unfollowingusername from the slice
-	var updatedFollowingList []string
-	for _, user := range followingList {
-		if user != unfollowingusername {
-			updatedFollowingList = append(updatedFollowingList, user)
+// checkUsersExist returns ErrUserNotFound if any of the given users doesn't exist
+func (db *appdbimpl) checkUsersExist(userIDs ...string) error {
+	for _, userID := range userIDs {
+		var exists bool
+		if err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM Users WHERE id = ?)", userID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, userID)
 		}
 	}
+	return nil
+}
 
-	// Join the updated slice back into a comma-separated string
-	updatedFollowing := strings.Join(updatedFollowingList, ",")
-
-	// Update the 'following' column for the user
-	_, err = db.c.Exec("UPDATE Users SET following = ? WHERE username = ?", updatedFollowing, username)
-	if err != nil {
-		return err
-	}
+// IsBanned returns true if the user `userID` is banned by `bannerID`
+func (db *appdbimpl) IsBanned(userID, bannerID string) (bool, error) {
+	var banned bool
+	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM Bans WHERE banner = ? AND banned = ?)", bannerID, userID).
+		Scan(&banned)
+	return banned, err
+}
 
-	return nil
+// isBannedEither returns true if either of the two users banned the other
+func (db *appdbimpl) isBannedEither(userID, otherUserID string) (bool, error) {
+	var banned bool
+	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM Bans WHERE (banner = ? AND banned = ?) OR (banner = ? AND banned = ?))",
+		userID, otherUserID, otherUserID, userID).Scan(&banned)
+	return banned, err
 }
 
-func (db *appdbimpl) BanUsername(username, banusername string) error {
-	// Check if the user exists
-	var count int
-	err := db.c.QueryRow("SELECT COUNT(*) FROM Users WHERE username = ?", username).Scan(&count)
-	if err != nil {
+// FollowUser adds `followedID` to the users followed by `userID`. It returns ErrBanned if either user banned the other,
+// and ErrSelfRelation if they are the same user.
+func (db *appdbimpl) FollowUser(userID, followedID string) error {
+	if userID == followedID {
+		return ErrSelfRelation
+	}
+	if err := db.checkUsersExist(userID, followedID); err != nil {
 		return err
 	}
-	if count == 0 {
-		return fmt.Errorf("user %s not found", username)
+	if banned, err := db.isBannedEither(userID, followedID); err != nil {
+		return err
+	} else if banned {
+		return ErrBanned
 	}
+	_, err := db.c.Exec("INSERT OR IGNORE INTO Follows (follower, followed, created_at) VALUES (?, ?, ?)",
+		userID, followedID, globaltime.Now().UTC())
+	return err
+}
 
-	var banned string
-	err = db.c.QueryRow("SELECT banned FROM Users WHERE username = ?", username).Scan(&banned)
-	if err != nil {
+func (db *appdbimpl) UnfollowUser(userID, followedID string) error {
+	if err := db.checkUsersExist(userID); err != nil {
 		return err
 	}
+	_, err := db.c.Exec("DELETE FROM Follows WHERE follower = ? AND followed = ?", userID, followedID)
+	return err
+}
 
-	if banned != "" {
-		banned += ","
+// BanUser adds `bannedID` to the users banned by `userID`. Any follow relationship between the two users, in either
+// direction, is removed. It returns ErrSelfRelation if they are the same user.
+func (db *appdbimpl) BanUser(userID, bannedID string) error {
+	if userID == bannedID {
+		return ErrSelfRelation
 	}
-	banned += banusername
-	_, err = db.c.Exec("UPDATE Users SET banned = ? WHERE username = ?", banned, username)
-	if err != nil {
+	if err := db.checkUsersExist(userID, bannedID); err != nil {
 		return err
 	}
-
-	return nil
+	return inTransaction(db.c, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT OR IGNORE INTO Bans (banner, banned, created_at) VALUES (?, ?, ?)",
+			userID, bannedID, globaltime.Now().UTC())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM Follows WHERE (follower = ? AND followed = ?) OR (follower = ? AND followed = ?)",
+			userID, bannedID, bannedID, userID)
+		return err
+	})
 }
 
-func (db *appdbimpl) UnbanUsername(username, unbanusername string) error {
-
-	// Check if the user exists
-	var count int
-	err := db.c.QueryRow("SELECT COUNT(*) FROM Users WHERE username = ?", username).Scan(&count)
-	if err != nil {
+func (db *appdbimpl) UnbanUser(userID, bannedID string) error {
+	if err := db.checkUsersExist(userID); err != nil {
 		return err
 	}
-	if count == 0 {
-		return fmt.Errorf("user %s not found", username)
-	}
+	_, err := db.c.Exec("DELETE FROM Bans WHERE banner = ? AND banned = ?", userID, bannedID)
+	return err
+}
 
-	var banned string
-	err = db.c.QueryRow("SELECT banned FROM Users WHERE username = ?", username).Scan(&banned)
-	if err != nil {
-		return err
+// GetUserPhotos returns a page of the images posted by the user `userID`, newest first. The list is empty if
+// `viewerID` is banned by the user.
+func (db *appdbimpl) GetUserPhotos(userID, viewerID string, page Page) ([]Image, string, error) {
+	if err := db.checkUsersExist(userID); err != nil {
+		return nil, "", err
 	}
+	return db.queryImages(viewerID, "Images.user_id = ?", []interface{}{userID}, page)
+}
 
-	bannedList := strings.Split(banned, ",")
+// UserSummary is an item of a list of users, as seen by a viewer
+type UserSummary struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
 
-	var updatedBannedList []string
-	for _, user := range bannedList {
-		if user != unbanusername {
-			updatedBannedList = append(updatedBannedList, user)
-		}
-	}
-
-	// Join the updated slice back into a comma-separated string
-	updatedBanned := strings.Join(updatedBannedList, ",")
+	// IsFollowing is true if the viewer follows the user
+	IsFollowing bool `json:"isFollowing"`
+}
 
-	// Update the 'following' column for the user
-	_, err = db.c.Exec("UPDATE Users SET banned = ? WHERE username = ?", updatedBanned, username)
-	if err != nil {
-		return err
-	}
+// GetFollowers returns a page of the users following `userID`, most recent first, as seen by `viewerID`. Users who
+// banned the viewer, or were banned by the viewer, are left out.
+func (db *appdbimpl) GetFollowers(userID, viewerID string, page Page) ([]UserSummary, string, error) {
+	return db.queryFollows("follower", "followed", userID, viewerID, page)
+}
 
-	return nil
+// GetFollowing returns a page of the users followed by `userID`, most recent first, as seen by `viewerID`. Users who
+// banned the viewer, or were banned by the viewer, are left out.
+func (db *appdbimpl) GetFollowing(userID, viewerID string, page Page) ([]UserSummary, string, error) {
+	return db.queryFollows("followed", "follower", userID, viewerID, page)
 }
 
-func (db *appdbimpl) GetUserPhotos(username string) ([]Image, error) {
-	rows, err := db.c.Query("SELECT id, imageurl, username, likes, comments, created_at FROM Images WHERE username = ? ORDER BY created_at DESC", username)
+// queryFollows returns a page of the users in the column `listed` of Follows, for the rows where the column `key` is
+// `userID`
+func (db *appdbimpl) queryFollows(listed, key, userID, viewerID string, page Page) ([]UserSummary, string, error) {
+	order := pageOrder{createdAt: "Follows.created_at", id: "Follows." + listed, desc: true}
+	clause, args, err := order.clause("Follows."+key+` = ?
+		AND NOT EXISTS(SELECT 1 FROM Bans WHERE (Bans.banner = Follows.`+listed+` AND Bans.banned = ?)
+			OR (Bans.banner = ? AND Bans.banned = Follows.`+listed+`))`,
+		[]interface{}{userID, viewerID, viewerID}, page)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	rows, err := db.c.Query(`SELECT Users.id, Users.username,
+		EXISTS(SELECT 1 FROM Follows AS Mine WHERE Mine.follower = ? AND Mine.followed = Follows.`+listed+`),
+		CAST(Follows.created_at AS TEXT) FROM Follows JOIN Users ON Users.id = Follows.`+listed+clause,
+		append([]interface{}{viewerID}, args...)...)
+	if err != nil {
+		return nil, "", err
 	}
 	defer rows.Close()
 
-	var images []Image
+	var users = []UserSummary{}
+	var keys []cursor
 	for rows.Next() {
-		var img Image
-		if err := rows.Scan(&img.ID, &img.ImageURL, &img.Username, &img.Likes, &img.Comments, &img.CreatedAt); err != nil {
-			return nil, err
+		var user UserSummary
+		var createdAt string
+		if err := rows.Scan(&user.ID, &user.Username, &user.IsFollowing, &createdAt); err != nil {
+			return nil, "", err
 		}
-		images = append(images, img)
+		users = append(users, user)
+		keys = append(keys, cursor{createdAt: createdAt, id: user.ID})
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return images, nil
+
+	n, next := nextPage(keys, page)
+	return users[:n], next, nil
 }
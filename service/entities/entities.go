@@ -0,0 +1,146 @@
+/*
+Package entities finds the entities written by users in captions and comments: #hashtags and @mentions.
+
+A hashtag is a `#` followed by letters, digits and underscores, not all digits, at most MaxHashtagLength of them. The
+`#` must not follow a letter, a digit or an underscore, so that "C#" or "a#b" are not hashtags. Hashtags are
+case-insensitive: they are normalized to lowercase.
+
+A mention is a `@` followed by a username made of letters, digits and underscores, possibly joined by dots and dashes
+("@john.doe"). As for hashtags, the `@` must not follow a letter, a digit or an underscore, so that e-mail addresses
+are not mentions. Whether the user exists is up to the caller. Usernames that can be mentioned are checked with
+IsUsername.
+
+	for _, tag := range entities.Hashtags("Sunset at the #Beach #beach #2023 with @bob") {
+		// "beach"
+	}
+	for _, mention := range entities.Mentions("Sunset at the #Beach #beach #2023 with @bob") {
+		// mention.Value is "bob", at text[mention.Start:mention.End]
+	}
+*/
+package entities
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxHashtagLength is the maximum number of characters of a hashtag, without the `#`. Longer words are not hashtags.
+const MaxHashtagLength = 50
+
+// Entity is an entity found in a text
+type Entity struct {
+	// Start and End are the byte offsets of the entity in the text, sigil included
+	Start int
+	End   int
+
+	// Value is the entity without the sigil, as written
+	Value string
+}
+
+// Hashtags returns the hashtags in the text, normalized, in order of appearance and without duplicates
+func Hashtags(text string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, e := range find(text, '#', "") {
+		tag, ok := NormalizeHashtag(e.Value)
+		if ok && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// NormalizeHashtag returns the hashtag in lowercase, without the leading `#` if any. It returns false if `tag` is not
+// a valid hashtag.
+func NormalizeHashtag(tag string) (string, bool) {
+	tag = strings.TrimPrefix(tag, "#")
+	if !IsHashtagPrefix(tag) || tag == "" || strings.TrimFunc(tag, unicode.IsDigit) == "" {
+		return "", false
+	}
+	return strings.ToLower(tag), true
+}
+
+// IsHashtagPrefix returns true if `prefix` (without the `#`) can be the start of a hashtag. The empty string is a
+// prefix of all the hashtags.
+func IsHashtagPrefix(prefix string) bool {
+	if !utf8.ValidString(prefix) || utf8.RuneCountInString(prefix) > MaxHashtagLength {
+		return false
+	}
+	for _, r := range prefix {
+		if !isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isWordRune returns true for the characters of hashtags
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// Mentions returns the mentions in the text, in order of appearance. Their value is the username, as written.
+func Mentions(text string) []Entity {
+	return find(text, '@', ".-")
+}
+
+// IsUsername returns true if `name` can be mentioned: the whole name would be found after a `@`
+func IsUsername(name string) bool {
+	found := Mentions("@" + name)
+	return len(found) == 1 && found[0].Value == name
+}
+
+// UTF16Length returns the length of the text in UTF-16 code units, which is how JavaScript measures strings. Offsets of
+// entities sent to the web UI are converted with it.
+func UTF16Length(text string) int {
+	n := 0
+	for _, r := range text {
+		// Characters outside the Basic Multilingual Plane (e.g., most emoji) take a surrogate pair
+		if r > 0xFFFF {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// find returns the words following `sigil` in the text, where the sigil is not in the middle of a word. Words can have
+// the `joiners` between two word characters. The value of the entities is the whole word: callers check its length and
+// content.
+func find(text string, sigil rune, joiners string) []Entity {
+	var found []Entity
+	prev := rune(-1)
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r != sigil || (prev != -1 && isWordRune(prev)) {
+			prev = r
+			i += size
+			continue
+		}
+
+		end := i + size
+		for end < len(text) {
+			next, n := utf8.DecodeRuneInString(text[end:])
+			if !isWordRune(next) {
+				if !strings.ContainsRune(joiners, next) || end == i+size {
+					break
+				}
+				// A joiner is part of the word only if it's followed by a word character
+				if after, _ := utf8.DecodeRuneInString(text[end+n:]); !isWordRune(after) {
+					break
+				}
+			}
+			end += n
+		}
+		prev = r
+		if end > i+size {
+			found = append(found, Entity{Start: i, End: end, Value: text[i+size : end]})
+			prev, _ = utf8.DecodeLastRuneInString(text[:end])
+		}
+		i = end
+	}
+	return found
+}
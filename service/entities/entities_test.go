@@ -0,0 +1,190 @@
+package entities
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHashtags(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"", nil},
+		{"no tags here", nil},
+		{"#beach", []string{"beach"}},
+		{"Sunset at the #Beach #beach #2023 with @bob", []string{"beach"}},
+		{"#one,#two.#three!", []string{"one", "two", "three"}},
+		{"#summer_2023 #2023summer", []string{"summer_2023", "2023summer"}},
+		// The `#` must start a word
+		{"C# and a#b and __#x", nil},
+		{"(#inside) «#quoted»", []string{"inside", "quoted"}},
+		{"## #", nil},
+		{"#Café #ÉTÉ #東京", []string{"café", "été", "東京"}},
+		// Combining marks are part of the word
+		{"#Cafe\u0301", []string{"cafe\u0301"}},
+		{"🎉#party", []string{"party"}},
+		{"#" + strings.Repeat("a", MaxHashtagLength), []string{strings.Repeat("a", MaxHashtagLength)}},
+		{"#" + strings.Repeat("a", MaxHashtagLength+1), nil},
+		{"#hash-tag #dotted.tag", []string{"hash", "dotted"}},
+	}
+	for _, tt := range tests {
+		if got := Hashtags(tt.text); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Hashtags(%q) = %q; expected %q", tt.text, got, tt.expected)
+		}
+	}
+}
+
+func TestNormalizeHashtag(t *testing.T) {
+	tests := []struct {
+		tag      string
+		expected string
+		ok       bool
+	}{
+		{"beach", "beach", true},
+		{"#Beach", "beach", true},
+		{"ÉTÉ", "été", true},
+		{"a1", "a1", true},
+		{"", "", false},
+		{"#", "", false},
+		{"2023", "", false},
+		{"two words", "", false},
+		{"##beach", "", false},
+		{"bad\xff", "", false},
+		{strings.Repeat("é", MaxHashtagLength), strings.Repeat("é", MaxHashtagLength), true},
+		{strings.Repeat("é", MaxHashtagLength+1), "", false},
+	}
+	for _, tt := range tests {
+		tag, ok := NormalizeHashtag(tt.tag)
+		if tag != tt.expected || ok != tt.ok {
+			t.Errorf("NormalizeHashtag(%q) = %q, %v; expected %q, %v", tt.tag, tag, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestIsHashtagPrefix(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		expected bool
+	}{
+		{"", true},
+		{"bea", true},
+		{"20", true},
+		{"#bea", false},
+		{"be ach", false},
+		{strings.Repeat("a", MaxHashtagLength+1), false},
+	}
+	for _, tt := range tests {
+		if got := IsHashtagPrefix(tt.prefix); got != tt.expected {
+			t.Errorf("IsHashtagPrefix(%q) = %v; expected %v", tt.prefix, got, tt.expected)
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []Entity
+	}{
+		{"", nil},
+		{"@bob", []Entity{{0, 4, "bob"}}},
+		{"hi @bob!", []Entity{{3, 7, "bob"}}},
+		{"@john.doe and @mary-jane_1.", []Entity{{0, 9, "john.doe"}, {14, 26, "mary-jane_1"}}},
+		// Joiners count only between word characters
+		{"@bob. @bob.. @.bob @bob-", []Entity{{0, 4, "bob"}, {6, 10, "bob"}, {19, 23, "bob"}}},
+		// E-mail addresses and handles in the middle of words are not mentions
+		{"bob@example.com x_@bob", nil},
+		{"@@bob @", []Entity{{1, 5, "bob"}}},
+		{"(@bob) «@zoë»", []Entity{{1, 5, "bob"}, {9, 14, "zoë"}}},
+		{"@ALICE @bob", []Entity{{0, 6, "ALICE"}, {7, 11, "bob"}}},
+		{"#tag@bob", nil},
+	}
+	for _, tt := range tests {
+		if got := Mentions(tt.text); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Mentions(%q) = %+v; expected %+v", tt.text, got, tt.expected)
+		}
+	}
+}
+
+func TestMentionOffsets(t *testing.T) {
+	// Offset and length in UTF-16 code units, as the web UI slices the text
+	tests := []struct {
+		text           string
+		offset, length int
+	}{
+		{"@bob", 0, 4},
+		{"é @bob", 2, 4},
+		{"東京 @bob", 3, 4},
+		// Characters outside the Basic Multilingual Plane are surrogate pairs in UTF-16
+		{"🎉 @bob", 3, 4},
+		{"🎉🎉🎉 @bob", 7, 4},
+		{"𝒜 @𝒜lice", 3, 7},
+		{"👍🏽 @bob", 5, 4},
+	}
+	for _, tt := range tests {
+		found := Mentions(tt.text)
+		if len(found) != 1 {
+			t.Errorf("Mentions(%q) = %+v; expected a mention", tt.text, found)
+			continue
+		}
+		e := found[0]
+		if tt.text[e.Start:e.End] != "@"+e.Value {
+			t.Errorf("Mentions(%q): %+v doesn't match the text", tt.text, e)
+		}
+		offset, length := UTF16Length(tt.text[:e.Start]), UTF16Length(tt.text[e.Start:e.End])
+		if offset != tt.offset || length != tt.length {
+			t.Errorf("Mentions(%q): expected offset %d and length %d, got %d and %d", tt.text, tt.offset, tt.length,
+				offset, length)
+		}
+	}
+}
+
+func TestUTF16Length(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"café", 4},
+		{"\uFFFF", 1},
+		{"\U00010000", 2},
+		{"🎉", 2},
+		{"👍🏽", 4},
+		{"a🎉b", 4},
+		// Invalid bytes are decoded as the replacement character
+		{"\xff", 1},
+	}
+	for _, tt := range tests {
+		if got := UTF16Length(tt.text); got != tt.expected {
+			t.Errorf("UTF16Length(%q) = %d; expected %d", tt.text, got, tt.expected)
+		}
+	}
+}
+
+func TestIsUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"bob", true},
+		{"Bob_1", true},
+		{"john.doe", true},
+		{"mary-jane", true},
+		{"zoë", true},
+		{"", false},
+		{"bob!", false},
+		{"dave smith", false},
+		{"bob.", false},
+		{".bob", false},
+		{"john..doe", false},
+		{"@bob", false},
+		{"bob@example", false},
+	}
+	for _, tt := range tests {
+		if got := IsUsername(tt.name); got != tt.expected {
+			t.Errorf("IsUsername(%q) = %v; expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// EXIF tags read from the photos
+const (
+	tagCameraModel        = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+)
+
+// exifDateLayout is the layout of the dates in EXIF
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// maxCameraModelLength is the maximum length of the camera model kept from the EXIF data
+const maxCameraModelLength = 64
+
+// errInvalidEXIF is returned for EXIF data that can't be parsed. Photos with invalid EXIF data are handled as if they
+// had none.
+var errInvalidEXIF = errors.New("invalid EXIF data")
+
+// exifData is what is read from the EXIF data of a photo
+type exifData struct {
+	// orientation is the value of the Orientation tag, from 1 (normal) to 8. It's 0 if missing.
+	orientation int
+	metadata    Metadata
+}
+
+// findEXIF returns the EXIF data (a TIFF structure) embedded in the photo: the APP1 segment of JPEG files, or the eXIf
+// chunk of PNG files. It returns nil if there's none.
+func findEXIF(data []byte, format string) []byte {
+	switch format {
+	case "jpeg":
+		var exif []byte
+		_ = walkJPEG(data, func(s jpegSegment) bool {
+			if s.marker == 0xE1 && bytes.HasPrefix(s.payload, []byte("Exif\x00\x00")) {
+				exif = s.payload[6:]
+				return false
+			}
+			// The metadata is before the image data
+			return s.marker != 0xDA
+		})
+		return exif
+	case "png":
+		var exif []byte
+		_ = walkPNG(data, func(typ string, chunk []byte) bool {
+			if typ == "eXIf" {
+				exif = chunk
+				return false
+			}
+			return true
+		})
+		return exif
+	}
+	return nil
+}
+
+// parseEXIF reads the orientation, the capture time and the camera model from EXIF data
+func parseEXIF(tiff []byte) (exifData, error) {
+	var result exifData
+	if len(tiff) < 8 {
+		return result, errInvalidEXIF
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return result, errInvalidEXIF
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return result, errInvalidEXIF
+	}
+
+	ifd0, err := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	if err != nil {
+		return result, err
+	}
+	if v, ok := ifd0.short(tagOrientation); ok && v >= 1 && v <= 8 {
+		result.orientation = int(v)
+	}
+	result.metadata.CameraModel = cleanText(ifd0.ascii(tagCameraModel), maxCameraModelLength)
+
+	// The capture time is in the Exif sub-IFD. DateTime (the last change of the file) is the fallback.
+	taken, offset := ifd0.ascii(tagDateTime), ""
+	if pointer, ok := ifd0.long(tagExifIFD); ok {
+		if sub, err := readIFD(tiff, order, pointer); err == nil {
+			if original := sub.ascii(tagDateTimeOriginal); original != "" {
+				taken, offset = original, sub.ascii(tagOffsetTimeOriginal)
+			}
+		}
+	}
+	result.metadata.TakenAt = parseEXIFTime(taken, offset)
+	return result, nil
+}
+
+// parseEXIFTime parses an EXIF date, with its time zone offset (e.g., "+02:00") if known. Dates without offset are
+// assumed to be UTC. It returns nil if the date is missing or invalid.
+func parseEXIFTime(date, offset string) *time.Time {
+	if date == "" {
+		return nil
+	}
+	loc := time.UTC
+	if offset != "" {
+		if t, err := time.Parse("-07:00", offset); err == nil {
+			loc = t.Location()
+		}
+	}
+	t, err := time.ParseInLocation(exifDateLayout, date, loc)
+	if err != nil || t.Year() < 1900 {
+		return nil
+	}
+	return &t
+}
+
+// cleanText trims an EXIF string, and drops it if it's not printable text
+func cleanText(s string, maxLength int) string {
+	s = strings.TrimSpace(s)
+	if !utf8.ValidString(s) || utf8.RuneCountInString(s) > maxLength {
+		return ""
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7F {
+			return ""
+		}
+	}
+	return s
+}
+
+// ifdEntry is an entry of an IFD (a directory of tags) in a TIFF structure
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// ifd maps the tags of an IFD to their entries
+type ifd struct {
+	order   binary.ByteOrder
+	entries map[uint16]ifdEntry
+}
+
+// TIFF types of the tags read from the photos
+const (
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+)
+
+// readIFD reads the IFD at `offset`. Values longer than 4 bytes are read from their offset.
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) (ifd, error) {
+	dir := ifd{order: order, entries: make(map[uint16]ifdEntry)}
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return dir, errInvalidEXIF
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	if start+n*12 > len(tiff) {
+		return dir, errInvalidEXIF
+	}
+	for i := 0; i < n; i++ {
+		entry := tiff[start+i*12 : start+i*12+12]
+		e := ifdEntry{typ: order.Uint16(entry[2:]), count: order.Uint32(entry[4:])}
+		var size uint64
+		switch e.typ {
+		case typeASCII:
+			size = uint64(e.count)
+		case typeShort:
+			size = 2 * uint64(e.count)
+		case typeLong:
+			size = 4 * uint64(e.count)
+		default:
+			// Other types are not needed
+			continue
+		}
+		if size <= 4 {
+			e.value = entry[8 : 8+size]
+		} else {
+			at := uint64(order.Uint32(entry[8:]))
+			if at+size > uint64(len(tiff)) {
+				continue
+			}
+			e.value = tiff[at : at+size]
+		}
+		dir.entries[order.Uint16(entry)] = e
+	}
+	return dir, nil
+}
+
+func (d ifd) short(tag uint16) (uint16, bool) {
+	e, ok := d.entries[tag]
+	if !ok || e.typ != typeShort || len(e.value) < 2 {
+		return 0, false
+	}
+	return d.order.Uint16(e.value), true
+}
+
+func (d ifd) long(tag uint16) (uint32, bool) {
+	e, ok := d.entries[tag]
+	if !ok || e.typ != typeLong || len(e.value) < 4 {
+		return 0, false
+	}
+	return d.order.Uint32(e.value), true
+}
+
+func (d ifd) ascii(tag uint16) string {
+	e, ok := d.entries[tag]
+	if !ok || e.typ != typeASCII {
+		return ""
+	}
+	// Strings are NUL-terminated
+	return strings.TrimRight(string(e.value), "\x00")
+}
+
+// orient transforms the image as described by the EXIF orientation, so that it's displayed upright without looking at
+// the metadata. Orientations 2 to 4 keep the size, and they are applied in place. Orientations 5 to 8 swap width and
+// height: they return a new image.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if orientation <= 4 {
+		mirror(src, orientation)
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Position of the source pixel in the upright image
+			var dx, dy int
+			switch orientation {
+			case 5: // Mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // Rotated 90° counterclockwise: turn it clockwise
+				dx, dy = h-1-y, x
+			case 7: // Mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 90° clockwise: turn it counterclockwise
+				dx, dy = y, w-1-x
+			}
+			i := y*src.Stride + x*4
+			j := dy*dst.Stride + dx*4
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// mirror applies the orientations 2 to 4 in place. Each of them swaps pairs of pixels.
+func mirror(img *image.RGBA, orientation int) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Position of the pixel in the upright image
+			dx, dy := x, y
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx = w - 1 - x
+			case 3: // Rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dy = h - 1 - y
+			}
+			i := y*img.Stride + x*4
+			j := dy*img.Stride + dx*4
+			if j > i {
+				for k := 0; k < 4; k++ {
+					img.Pix[i+k], img.Pix[j+k] = img.Pix[j+k], img.Pix[i+k]
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// exifEntry is a tag of an IFD built by buildTIFF. The value is a string (ASCII), an uint16 (SHORT) or an uint32
+// (LONG).
+type exifEntry struct {
+	tag   uint16
+	value interface{}
+}
+
+// buildTIFF returns EXIF data with the tags of IFD0 and, if `sub` is not nil, an Exif IFD with the tags of `sub`
+func buildTIFF(order binary.ByteOrder, ifd0, sub []exifEntry) []byte {
+	if sub != nil {
+		ifd0 = append(ifd0[:len(ifd0):len(ifd0)], exifEntry{tag: tagExifIFD})
+	}
+	subAt := 8 + 2 + 12*len(ifd0) + 4
+	size := subAt
+	if sub != nil {
+		size += 2 + 12*len(sub) + 4
+	}
+	tiff := make([]byte, size)
+	copy(tiff, "II")
+	if order == binary.BigEndian {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+
+	// Values longer than 4 bytes are appended after the IFDs
+	writeIFD := func(at int, entries []exifEntry) {
+		order.PutUint16(tiff[at:], uint16(len(entries)))
+		for i, e := range entries {
+			o := at + 2 + 12*i
+			order.PutUint16(tiff[o:], e.tag)
+			order.PutUint32(tiff[o+4:], 1)
+			switch v := e.value.(type) {
+			case string:
+				s := v + "\x00"
+				order.PutUint16(tiff[o+2:], typeASCII)
+				order.PutUint32(tiff[o+4:], uint32(len(s)))
+				if len(s) <= 4 {
+					copy(tiff[o+8:], s)
+				} else {
+					order.PutUint32(tiff[o+8:], uint32(len(tiff)))
+					tiff = append(tiff, s...)
+				}
+			case uint16:
+				order.PutUint16(tiff[o+2:], typeShort)
+				order.PutUint16(tiff[o+8:], v)
+			case uint32:
+				order.PutUint16(tiff[o+2:], typeLong)
+				order.PutUint32(tiff[o+8:], v)
+			case nil:
+				order.PutUint16(tiff[o+2:], typeLong)
+				order.PutUint32(tiff[o+8:], uint32(subAt))
+			}
+		}
+	}
+	writeIFD(8, ifd0)
+	if sub != nil {
+		writeIFD(subAt, sub)
+	}
+	return tiff
+}
+
+// withEXIF returns the JPEG file with the EXIF data in an APP1 segment, after the start of image marker
+func withEXIF(data, tiff []byte) []byte {
+	return withSegment(data, 0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+// withSegment returns the JPEG file with a segment after the start of image marker
+func withSegment(data []byte, marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	return insertAt(data, 2, append(segment, payload...))
+}
+
+func TestParseEXIF(t *testing.T) {
+	phone := func(order binary.ByteOrder) []byte {
+		return buildTIFF(order,
+			[]exifEntry{{tagCameraModel, "Pixel 7"}, {tagOrientation, uint16(6)}, {tagDateTime, "2024:01:01 00:00:00"}},
+			[]exifEntry{{tagDateTimeOriginal, "2023:05:01 10:20:30"}, {tagOffsetTimeOriginal, "+02:00"}})
+	}
+	takenAt := time.Date(2023, 5, 1, 8, 20, 30, 0, time.UTC)
+	modifiedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		tiff        []byte
+		orientation int
+		camera      string
+		takenAt     *time.Time
+	}{
+		{"little endian", phone(binary.LittleEndian), 6, "Pixel 7", &takenAt},
+		{"big endian", phone(binary.BigEndian), 6, "Pixel 7", &takenAt},
+		{"no Exif IFD", buildTIFF(binary.LittleEndian, []exifEntry{{tagDateTime, "2024:01:01 00:00:00"}}, nil), 0, "",
+			&modifiedAt},
+		{"short model", buildTIFF(binary.BigEndian, []exifEntry{{tagCameraModel, "X1"}}, nil), 0, "X1", nil},
+		{"orientation out of range", buildTIFF(binary.LittleEndian, []exifEntry{{tagOrientation, uint16(9)}}, nil), 0,
+			"", nil},
+		{"orientation of the wrong type", buildTIFF(binary.LittleEndian,
+			[]exifEntry{{tagOrientation, uint32(3)}}, nil), 0, "", nil},
+		{"control characters", buildTIFF(binary.LittleEndian, []exifEntry{{tagCameraModel, "Pixel\n7"}}, nil), 0, "",
+			nil},
+		{"invalid date", buildTIFF(binary.LittleEndian, []exifEntry{{tagDateTime, "0000:00:00 00:00:00"}}, nil), 0, "",
+			nil},
+	}
+	for _, tt := range tests {
+		exif, err := parseEXIF(tt.tiff)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if exif.orientation != tt.orientation {
+			t.Errorf("%s: expected orientation %d, got %d", tt.name, tt.orientation, exif.orientation)
+		}
+		if exif.metadata.CameraModel != tt.camera {
+			t.Errorf("%s: expected camera %q, got %q", tt.name, tt.camera, exif.metadata.CameraModel)
+		}
+		got := exif.metadata.TakenAt
+		if (got == nil) != (tt.takenAt == nil) || got != nil && !got.Equal(*tt.takenAt) {
+			t.Errorf("%s: expected capture time %v, got %v", tt.name, tt.takenAt, got)
+		}
+	}
+}
+
+func TestParseEXIFInvalid(t *testing.T) {
+	valid := buildTIFF(binary.LittleEndian, []exifEntry{{tagOrientation, uint16(6)}}, nil)
+	beyond := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(beyond[4:], 1000)
+
+	for name, tiff := range map[string][]byte{
+		"empty":              nil,
+		"short":              valid[:7],
+		"no byte order":      append([]byte("XX"), valid[2:]...),
+		"wrong byte order":   append([]byte("MM"), valid[2:]...),
+		"IFD beyond":         beyond,
+		"truncated IFD":      valid[:12],
+		"wrong magic number": append([]byte("II\x2B\x00"), valid[4:]...),
+	} {
+		if _, err := parseEXIF(tiff); err != errInvalidEXIF {
+			t.Errorf("%s: expected errInvalidEXIF, got %v", name, err)
+		}
+	}
+}
+
+// letters returns an image whose pixels have the letters of the rows as red value, to follow them when transformed
+func letters(rows ...string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x := range row {
+			img.SetRGBA(x, y, color.RGBA{R: row[x], A: 255})
+		}
+	}
+	return img
+}
+
+// rows returns the rows of an image made by letters
+func rows(img *image.RGBA) []string {
+	var rows []string
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row []byte
+		for x := b.Min.X; x < b.Max.X; x++ {
+			row = append(row, img.RGBAAt(x, y).R)
+		}
+		rows = append(rows, string(row))
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	// Each case is the stored image "abc/def" turned upright
+	tests := []struct {
+		orientation int
+		upright     []string
+	}{
+		{0, []string{"abc", "def"}},
+		{1, []string{"abc", "def"}},
+		{2, []string{"cba", "fed"}},
+		{3, []string{"fed", "cba"}},
+		{4, []string{"def", "abc"}},
+		{5, []string{"ad", "be", "cf"}},
+		{6, []string{"da", "eb", "fc"}},
+		{7, []string{"fc", "eb", "da"}},
+		{8, []string{"cf", "be", "ad"}},
+		{9, []string{"abc", "def"}},
+	}
+	for _, tt := range tests {
+		got := rows(orient(letters("abc", "def"), tt.orientation))
+		if len(got) != len(tt.upright) {
+			t.Errorf("orientation %d: expected %q, got %q", tt.orientation, tt.upright, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.upright[i] {
+				t.Errorf("orientation %d: expected %q, got %q", tt.orientation, tt.upright, got)
+				break
+			}
+		}
+	}
+}
+
+func TestProcessRotatedJPEG(t *testing.T) {
+	tiff := buildTIFF(binary.LittleEndian, []exifEntry{{tagCameraModel, "Pixel 7"}, {tagOrientation, uint16(6)}},
+		[]exifEntry{{tagDateTimeOriginal, "2023:05:01 10:20:30"}})
+	photo, err := Process(bytes.NewReader(withEXIF(encodeJPEG(t, 200, 400), tiff)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if photo.Width != 400 || photo.Height != 200 {
+		t.Errorf("expected a 400x200 photo, got %dx%d", photo.Width, photo.Height)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(photo.Data))
+	if err != nil || format != "jpeg" || config.Width != 400 || config.Height != 200 {
+		t.Errorf("expected a 400x200 JPEG file, got %s %dx%d (%v)", format, config.Width, config.Height, err)
+	}
+	err = walkJPEG(photo.Data, func(s jpegSegment) bool {
+		if s.marker == 0xE1 {
+			t.Errorf("expected no APP1 segment, got %q", s.payload)
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if bytes.Contains(photo.Data, []byte("Exif")) || bytes.Contains(photo.Data, []byte("Pixel 7")) {
+		t.Error("expected the EXIF data to be stripped")
+	}
+	if photo.Metadata.CameraModel != "Pixel 7" || photo.Metadata.TakenAt == nil {
+		t.Errorf("expected the metadata to be read before stripping it, got %+v", photo.Metadata)
+	}
+}
+
+func TestProcessUprightJPEG(t *testing.T) {
+	// Without rotation, the image data is kept as it is: only the APP1 segment goes away
+	plain := encodeJPEG(t, 200, 100)
+	for _, orientation := range []uint16{1, 9} {
+		tiff := buildTIFF(binary.BigEndian, []exifEntry{{tagOrientation, orientation}}, nil)
+		photo, err := Process(bytes.NewReader(withEXIF(plain, tiff)))
+		if err != nil {
+			t.Errorf("orientation %d: %v", orientation, err)
+		} else if !bytes.Equal(photo.Data, plain) || photo.Width != 200 || photo.Height != 100 {
+			t.Errorf("orientation %d: expected the photo to be copied as it is", orientation)
+		}
+	}
+}
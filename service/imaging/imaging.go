@@ -0,0 +1,303 @@
+/*
+Package imaging prepares uploaded photos to be published. Process decodes the photo and:
+
+  - applies the EXIF orientation to the pixels, so that the photo is displayed upright without looking at the metadata;
+  - strips all metadata (EXIF with GPS coordinates and device serials, XMP, comments...) from the bytes to store;
+  - reads the capture time and the camera model, that the owner can choose to publish;
+  - produces the variants: copies resized to the fixed widths in Widths, preserving the aspect ratio, so that clients
+    don't need to download the full photo to show it small.
+
+Only the image packages of the standard library are used. JPEG, PNG and GIF photos are supported (for animated GIFs,
+the variants are made from the first frame). Variants of JPEG photos are JPEG, the others are PNG, to keep
+transparency.
+
+	photo, err := imaging.Process(file)
+	if err != nil {
+		// imaging.ErrTooLarge, or the photo can't be decoded
+	}
+	// save photo.Data, whose MIME type is photo.ContentType
+	for _, v := range photo.Variants {
+		// save v.Data, whose MIME type is v.ContentType
+	}
+*/
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"time"
+)
+
+// Widths are the widths in pixels of the variants of a photo, ascending
+var Widths = []int{150, 640, 1080}
+
+// MaxPixels is the maximum number of pixels of a photo: larger photos are refused before decoding them, as they would
+// take too much memory. Processing a photo takes up to about 10 bytes per pixel (the decoded photo, its RGBA copy and
+// the rotated copy), so 24 megapixels take up to about 240MB.
+const MaxPixels = 24000000
+
+// maxConcurrent is the maximum number of photos processed at the same time. Further calls of Process wait, so that
+// concurrent uploads don't take more than maxConcurrent times the memory of the largest photo.
+const maxConcurrent = 2
+
+// slots has a value for each photo being processed
+var slots = make(chan struct{}, maxConcurrent)
+
+// jpegQuality is the quality of the JPEG variants
+const jpegQuality = 85
+
+// orientedJPEGQuality is the quality of JPEG photos re-encoded after applying their orientation. It's higher than the
+// quality of the variants, as they replace the original.
+const orientedJPEGQuality = 92
+
+// ErrTooLarge is returned for photos with more than MaxPixels pixels, counting all the frames of animated GIFs
+var ErrTooLarge = errors.New("image has too many pixels")
+
+// Variant is a resized copy of a photo
+type Variant struct {
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// Metadata is the subset of the metadata of a photo that can be published
+type Metadata struct {
+	// TakenAt is the capture time, nil if unknown. It's in UTC if the camera didn't record the time zone.
+	TakenAt *time.Time
+	// CameraModel is the model of the camera, empty if unknown
+	CameraModel string
+}
+
+// Photo is an uploaded photo, ready to be stored
+type Photo struct {
+	// Data is the photo without metadata, upright
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+	// Metadata is read from the EXIF data of the uploaded photo. It's not in Data.
+	Metadata Metadata
+	// Variants are the resized copies of the photo, narrowest first
+	Variants []Variant
+}
+
+// IsWidth returns true if `width` is one of the widths of the variants
+func IsWidth(width int) bool {
+	for _, w := range Widths {
+		if w == width {
+			return true
+		}
+	}
+	return false
+}
+
+// Process reads the photo from `r`, decodes it, strips its metadata and makes its variants. Photos are never enlarged:
+// only the variants narrower than the photo are made, so the list is empty for small photos.
+//
+// When there's nothing to rotate, the image data is copied as it is, so that the photo doesn't lose quality; otherwise
+// the photo is re-encoded. Invalid EXIF data is ignored.
+//
+// Photos with too many pixels are refused after reading their header. At most maxConcurrent photos are processed at the
+// same time, and the rest of the photo is read only once it's its turn: `r` should be a local file rather than the
+// request body, so that slow clients don't hold the turn.
+func Process(r io.Reader) (Photo, error) {
+	// The bytes read to decode the header are kept, as the photo is read only once
+	var buf bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		return Photo{}, err
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return Photo{}, errors.New("image has no pixels")
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return Photo{}, ErrTooLarge
+	}
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return Photo{}, err
+	}
+	data := buf.Bytes()
+	if format == "gif" {
+		// All the frames are decoded to strip the metadata (see clean)
+		frames, err := gifFrames(data)
+		if err != nil {
+			return Photo{}, err
+		}
+		if int64(frames)*int64(config.Width)*int64(config.Height) > MaxPixels {
+			return Photo{}, ErrTooLarge
+		}
+	}
+
+	var exif exifData
+	if tiff := findEXIF(data, format); tiff != nil {
+		if parsed, err := parseEXIF(tiff); err == nil {
+			exif = parsed
+		}
+	}
+
+	// The decoded image is dropped once converted, and only rotations that swap width and height make another copy
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Photo{}, err
+	}
+	src := orient(toRGBA(img), exif.orientation)
+
+	photo := Photo{
+		ContentType: "image/" + format,
+		Width:       src.Bounds().Dx(),
+		Height:      src.Bounds().Dy(),
+		Metadata:    exif.metadata,
+	}
+	photo.Data, err = clean(data, format, src, exif.orientation > 1)
+	if err != nil {
+		return Photo{}, err
+	}
+
+	for _, width := range Widths {
+		if width >= photo.Width {
+			break
+		}
+		resized := Resize(src, width)
+		var buf bytes.Buffer
+		contentType := "image/png"
+		if format == "jpeg" {
+			contentType = "image/jpeg"
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return Photo{}, fmt.Errorf("encoding the %dpx variant: %w", width, err)
+		}
+		photo.Variants = append(photo.Variants, Variant{
+			Width:       width,
+			Height:      resized.Bounds().Dy(),
+			ContentType: contentType,
+			Data:        buf.Bytes(),
+		})
+	}
+	return photo, nil
+}
+
+// clean returns the photo without metadata. `oriented` is the decoded photo, upright: it's encoded again if `rotated`,
+// or if the metadata can't be stripped from the original bytes. Photos encoded again keep their ICC profile.
+func clean(data []byte, format string, oriented *image.RGBA, rotated bool) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		if !rotated {
+			if stripped, err := stripJPEG(data); err == nil {
+				return stripped, nil
+			}
+		}
+		if err := jpeg.Encode(&buf, oriented, &jpeg.Options{Quality: orientedJPEGQuality}); err != nil {
+			return nil, err
+		}
+		// After the start of image marker
+		return insertAt(buf.Bytes(), 2, jpegICCProfile(data)), nil
+	case "png":
+		if !rotated {
+			if stripped, err := stripPNG(data); err == nil {
+				return stripped, nil
+			}
+		}
+		if err := png.Encode(&buf, oriented); err != nil {
+			return nil, err
+		}
+		// After the signature and the IHDR chunk, which the encoder writes first
+		return insertAt(buf.Bytes(), len(pngSignature)+25, pngICCProfile(data)), nil
+	case "gif":
+		// GIF has no orientation: encoding the frames again drops the comments and application extensions
+		animation, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if err := gif.EncodeAll(&buf, animation); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported image format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// insertAt returns `data` with `insert` at the offset `at`
+func insertAt(data []byte, at int, insert []byte) []byte {
+	if len(insert) == 0 {
+		return data
+	}
+	out := make([]byte, 0, len(data)+len(insert))
+	out = append(out, data[:at]...)
+	out = append(out, insert...)
+	return append(out, data[at:]...)
+}
+
+// Resize scales the image to the given width, preserving the aspect ratio. Each pixel of the result is the average of
+// the pixels of the source it covers (a box filter), which is accurate when shrinking.
+func Resize(src *image.RGBA, width int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	height := (sh*width + sw/2) / sw
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for dy := 0; dy < height; dy++ {
+		y0, y1 := span(dy, height, sh)
+		for dx := 0; dx < width; dx++ {
+			x0, x1 := span(dx, width, sw)
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				i := y*src.Stride + x0*4
+				for x := x0; x < x1; x++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					b += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+			j := dy*dst.Stride + dx*4
+			dst.Pix[j] = uint8((r + n/2) / n)
+			dst.Pix[j+1] = uint8((g + n/2) / n)
+			dst.Pix[j+2] = uint8((b + n/2) / n)
+			dst.Pix[j+3] = uint8((a + n/2) / n)
+		}
+	}
+	return dst
+}
+
+// span returns the range of source coordinates covered by the destination coordinate `d`, when scaling `srcSize` pixels
+// to `dstSize`. The range is never empty.
+func span(d, dstSize, srcSize int) (int, int) {
+	from := d * srcSize / dstSize
+	to := (d + 1) * srcSize / dstSize
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+// toRGBA converts the image to RGBA (premultiplied alpha), with the origin in (0, 0)
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
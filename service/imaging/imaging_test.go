@@ -0,0 +1,195 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encodePNG returns a PNG image of the given size
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeJPEG returns a JPEG image of the given size
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngChunk returns a PNG chunk with its length and checksum
+func pngChunk(typ string, data []byte) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(append(chunk, typ...), data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestSpan(t *testing.T) {
+	tests := []struct {
+		d, dstSize, srcSize int
+		from, to            int
+	}{
+		{0, 2, 4, 0, 2},
+		{1, 2, 4, 2, 4},
+		{0, 3, 10, 0, 3},
+		{1, 3, 10, 3, 6},
+		{2, 3, 10, 6, 10},
+		// Enlarging: each destination pixel covers a single source pixel
+		{0, 4, 2, 0, 1},
+		{1, 4, 2, 0, 1},
+		{3, 4, 2, 1, 2},
+	}
+	for _, tt := range tests {
+		from, to := span(tt.d, tt.dstSize, tt.srcSize)
+		if from != tt.from || to != tt.to {
+			t.Errorf("span(%d, %d, %d) = %d, %d; expected %d, %d", tt.d, tt.dstSize, tt.srcSize, from, to, tt.from,
+				tt.to)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		width, height, to int
+		expectedHeight    int
+	}{
+		{100, 50, 10, 5},
+		{100, 100, 30, 30},
+		{3, 1000, 1, 333},
+		// The height is rounded, and never zero
+		{100, 15, 10, 2},
+		{1000, 1, 10, 1},
+	}
+	for _, tt := range tests {
+		src := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+		dst := Resize(src, tt.to)
+		if dst.Bounds() != image.Rect(0, 0, tt.to, tt.expectedHeight) {
+			t.Errorf("resizing %dx%d to %d: expected %dx%d, got %v", tt.width, tt.height, tt.to, tt.to,
+				tt.expectedHeight, dst.Bounds())
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	// Left half red, right half transparent: each half becomes a pixel
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			src.SetRGBA(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	src.SetRGBA(0, 0, color.RGBA{R: 100, A: 255})
+
+	dst := Resize(src, 2)
+	if got, expected := dst.RGBAAt(0, 0), (color.RGBA{R: 175, A: 255}); got != expected {
+		t.Errorf("expected the left pixel to be %v, got %v", expected, got)
+	}
+	if got, expected := dst.RGBAAt(1, 0), (color.RGBA{}); got != expected {
+		t.Errorf("expected the right pixel to be %v, got %v", expected, got)
+	}
+}
+
+func TestIsWidth(t *testing.T) {
+	for _, width := range Widths {
+		if !IsWidth(width) {
+			t.Errorf("expected %d to be a width", width)
+		}
+	}
+	for _, width := range []int{0, -150, 151, 2000} {
+		if IsWidth(width) {
+			t.Errorf("expected %d not to be a width", width)
+		}
+	}
+}
+
+func TestProcessVariants(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         []byte
+		contentType  string
+		variantType  string
+		widths       []int
+		variantTotal int
+	}{
+		{"small PNG", encodePNG(t, 100, 50), "image/png", "", nil, 0},
+		{"PNG", encodePNG(t, 700, 350), "image/png", "image/png", []int{150, 640}, 2},
+		{"JPEG", encodeJPEG(t, 1200, 600), "image/jpeg", "image/jpeg", []int{150, 640, 1080}, 3},
+		// Photos as wide as a variant don't get it
+		{"exact width", encodePNG(t, 640, 10), "image/png", "image/png", []int{150}, 1},
+	}
+	for _, tt := range tests {
+		photo, err := Process(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if photo.ContentType != tt.contentType {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.contentType, photo.ContentType)
+		}
+		if len(photo.Variants) != tt.variantTotal {
+			t.Errorf("%s: expected %d variants, got %d", tt.name, tt.variantTotal, len(photo.Variants))
+			continue
+		}
+		for i, v := range photo.Variants {
+			config, _, err := image.DecodeConfig(bytes.NewReader(v.Data))
+			switch {
+			case err != nil:
+				t.Errorf("%s: decoding the %dpx variant: %v", tt.name, v.Width, err)
+			case v.Width != tt.widths[i] || config.Width != v.Width || config.Height != v.Height:
+				t.Errorf("%s: expected a %dpx variant, got %+v (%dx%d)", tt.name, tt.widths[i], v, config.Width,
+					config.Height)
+			case v.Height != (photo.Height*v.Width+photo.Width/2)/photo.Width:
+				t.Errorf("%s: the %dpx variant doesn't keep the aspect ratio (%d high)", tt.name, v.Width, v.Height)
+			case v.ContentType != tt.variantType:
+				t.Errorf("%s: expected %s variants, got %s", tt.name, tt.variantType, v.ContentType)
+			}
+		}
+	}
+}
+
+func TestProcessTooLarge(t *testing.T) {
+	// Only the header is read: the pixels are never decoded
+	header := func(width, height uint32) []byte {
+		ihdr := make([]byte, 13)
+		binary.BigEndian.PutUint32(ihdr, width)
+		binary.BigEndian.PutUint32(ihdr[4:], height)
+		ihdr[8], ihdr[9] = 8, 6
+		return append([]byte(pngSignature), pngChunk("IHDR", ihdr)...)
+	}
+	tests := []struct {
+		name          string
+		width, height uint32
+	}{
+		{"too many pixels", 10000, MaxPixels/10000 + 1},
+		{"too wide", 1 << 30, 1},
+	}
+	for _, tt := range tests {
+		if _, err := Process(bytes.NewReader(header(tt.width, tt.height))); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: expected ErrTooLarge, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestProcessInvalid(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("not an image"), encodePNG(t, 10, 10)[:40]} {
+		if _, err := Process(bytes.NewReader(data)); err == nil {
+			t.Errorf("expected an error for %q", data)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// jpegSegment is a marker segment of a JPEG file: data[start:end] is the marker followed by the payload, if any
+type jpegSegment struct {
+	marker     byte
+	start, end int
+	payload    []byte
+}
+
+// walkJPEG calls `fn` for each marker segment of the JPEG file, up to the end of image marker or until `fn` returns
+// false. Fill bytes and entropy-coded data are between the segments. It returns an error if the file is truncated or
+// malformed.
+func walkJPEG(data []byte, fn func(s jpegSegment) bool) error {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return errors.New("not a JPEG file")
+	}
+	i := 2
+	for {
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return errors.New("malformed JPEG file")
+		}
+		s := jpegSegment{marker: data[i+1], start: i, end: i + 2}
+		switch {
+		case s.marker == 0xFF:
+			// Fill byte
+			i++
+			continue
+		case s.marker == 0xD9:
+			fn(s)
+			return nil
+		case s.marker >= 0xD0 && s.marker <= 0xD7, s.marker == 0x01:
+			// Markers without payload
+		default:
+			if i+4 > len(data) {
+				return errors.New("truncated JPEG file")
+			}
+			s.end = i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+			if s.end < i+4 || s.end > len(data) {
+				return errors.New("truncated JPEG file")
+			}
+			s.payload = data[i+4 : s.end]
+		}
+		if !fn(s) {
+			return nil
+		}
+		i = s.end
+
+		if s.marker == 0xDA {
+			// The scan header is followed by entropy-coded data, where 0xFF bytes are followed by zero (stuffing) or
+			// by a restart marker
+			for i+1 < len(data) && (data[i] != 0xFF || data[i+1] == 0 || (data[i+1] >= 0xD0 && data[i+1] <= 0xD7)) {
+				i++
+			}
+		}
+	}
+}
+
+// stripJPEG returns the JPEG file without metadata: application segments (EXIF, XMP, maker notes...) and comments are
+// removed, except the JFIF and Adobe headers and the ICC profile, which are needed to render the colors correctly (e.g.,
+// the Display P3 colors of phone photos). Data after the end of the image (e.g., the previews appended by some phones)
+// is removed too. The image data is copied as it is.
+func stripJPEG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	prev := 2
+	err := walkJPEG(data, func(s jpegSegment) bool {
+		// Fill bytes or entropy-coded data
+		out = append(out, data[prev:s.start]...)
+		if keepJPEGSegment(s.marker, s.payload) {
+			out = append(out, data[s.start:s.end]...)
+		}
+		prev = s.end
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// jpegICCPrefix starts the APP2 segments with the ICC profile of a JPEG file. Large profiles span several segments.
+const jpegICCPrefix = "ICC_PROFILE\x00"
+
+// jpegICCProfile returns the segments of the JPEG file with the ICC profile, as they are. It returns nil if there's none.
+func jpegICCProfile(data []byte) []byte {
+	var profile []byte
+	_ = walkJPEG(data, func(s jpegSegment) bool {
+		if s.marker == 0xE2 && bytes.HasPrefix(s.payload, []byte(jpegICCPrefix)) {
+			profile = append(profile, data[s.start:s.end]...)
+		}
+		return s.marker != 0xDA
+	})
+	return profile
+}
+
+// keepJPEGSegment returns true for the segments kept by stripJPEG
+func keepJPEGSegment(marker byte, segment []byte) bool {
+	switch {
+	case marker == 0xE0:
+		return bytes.HasPrefix(segment, []byte("JFIF\x00"))
+	case marker == 0xE2:
+		return bytes.HasPrefix(segment, []byte(jpegICCPrefix))
+	case marker == 0xEE:
+		return bytes.HasPrefix(segment, []byte("Adobe"))
+	case marker > 0xE0 && marker <= 0xEF, marker == 0xFE:
+		return false
+	}
+	return true
+}
+
+// gifFrames returns the number of frames of the GIF file, without decoding them. It returns an error if the file is
+// truncated or malformed.
+func gifFrames(data []byte) (int, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return 0, errors.New("not a GIF file")
+	}
+	// The logical screen descriptor is followed by the global color table, if any
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	frames := 0
+	for {
+		if i >= len(data) {
+			return 0, errors.New("truncated GIF file")
+		}
+		switch data[i] {
+		case 0x21:
+			// Extension: label, then data sub-blocks
+			i += 2
+		case 0x2C:
+			// Image descriptor, then local color table (if any), LZW code size and data sub-blocks
+			if i+10 > len(data) {
+				return 0, errors.New("truncated GIF file")
+			}
+			frames++
+			if data[i+9]&0x80 != 0 {
+				i += 3 << (data[i+9]&0x07 + 1)
+			}
+			i += 11
+		case 0x3B:
+			return frames, nil
+		default:
+			return 0, errors.New("malformed GIF file")
+		}
+		// Sub-blocks start with their size, and end with an empty one
+		for {
+			if i >= len(data) {
+				return 0, errors.New("truncated GIF file")
+			}
+			n := int(data[i])
+			i += 1 + n
+			if n == 0 {
+				break
+			}
+		}
+	}
+}
+
+// pngSignature is the signature at the start of PNG files
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// walkPNG calls `fn` for each chunk of the PNG file, with its type and data, until the IEND chunk or until `fn` returns
+// false. It returns an error if the file is truncated or malformed.
+func walkPNG(data []byte, fn func(typ string, chunk []byte) bool) error {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return errors.New("not a PNG file")
+	}
+	i := len(pngSignature)
+	for {
+		if i+8 > len(data) {
+			return errors.New("truncated PNG file")
+		}
+		n := uint64(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		end := uint64(i) + 12 + n
+		if end > uint64(len(data)) {
+			return errors.New("truncated PNG file")
+		}
+		chunk := data[i+8 : uint64(i)+8+n]
+		if crc32.ChecksumIEEE(data[i+4:uint64(i)+8+n]) != binary.BigEndian.Uint32(data[uint64(i)+8+n:]) {
+			return errors.New("invalid PNG chunk checksum")
+		}
+		if !fn(typ, chunk) {
+			return nil
+		}
+		i = int(end)
+		if typ == "IEND" {
+			return nil
+		}
+	}
+}
+
+// pngKeptChunks are the ancillary chunks kept by stripPNG: the ones needed to render the image (transparency, colors,
+// animation). Critical chunks are always kept.
+var pngKeptChunks = map[string]bool{
+	"tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true, "sBIT": true, "bKGD": true, "pHYs": true,
+	"acTL": true, "fcTL": true, "fdAT": true,
+}
+
+// pngICCProfile returns the iCCP chunk of the PNG file, whole. It returns nil if there's none.
+func pngICCProfile(data []byte) []byte {
+	var profile []byte
+	i := len(pngSignature)
+	_ = walkPNG(data, func(typ string, chunk []byte) bool {
+		end := i + 12 + len(chunk)
+		if typ == "iCCP" {
+			profile = data[i:end]
+			return false
+		}
+		i = end
+		return typ != "IDAT"
+	})
+	return profile
+}
+
+// stripPNG returns the PNG file without metadata: text chunks, EXIF, modification time and unknown ancillary chunks are
+// removed. The image data is copied as it is.
+func stripPNG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	i := len(pngSignature)
+	err := walkPNG(data, func(typ string, chunk []byte) bool {
+		end := i + 12 + len(chunk)
+		// Critical chunks have an uppercase first letter
+		if typ[0] >= 'A' && typ[0] <= 'Z' || pngKeptChunks[typ] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,214 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// iccProfile is the start of an ICC profile, enough to recognize it in the output
+const iccProfile = "\x00\x00\x0c\x48Lino\x02\x10\x00\x00mntrRGB XYZ "
+
+// withChunk returns the PNG file with a chunk after the IHDR chunk
+func withChunk(data []byte, typ string, chunk []byte) []byte {
+	return insertAt(data, len(pngSignature)+25, pngChunk(typ, chunk))
+}
+
+func TestStripJPEG(t *testing.T) {
+	tests := []struct {
+		name    string
+		marker  byte
+		payload string
+		kept    bool
+	}{
+		{"JFIF", 0xE0, "JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00", true},
+		{"JFIF extension", 0xE0, "JFXX\x00\x10thumbnail", false},
+		{"EXIF", 0xE1, "Exif\x00\x00MM\x00\x2a", false},
+		{"XMP", 0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>", false},
+		{"ICC profile", 0xE2, jpegICCPrefix + "\x01\x01" + iccProfile, true},
+		{"FlashPix", 0xE2, "FPXR\x00\x00", false},
+		{"Photoshop", 0xED, "Photoshop 3.0\x00", false},
+		{"Adobe", 0xEE, "Adobe\x00\x64\x00\x00\x00\x00\x01", true},
+		{"comment", 0xFE, "made with love", false},
+	}
+	plain := encodeJPEG(t, 16, 8)
+	for _, tt := range tests {
+		expected := plain
+		if tt.kept {
+			expected = withSegment(plain, tt.marker, []byte(tt.payload))
+		}
+		stripped, err := stripJPEG(withSegment(plain, tt.marker, []byte(tt.payload)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !bytes.Equal(stripped, expected) {
+			t.Errorf("%s: expected %q, got %q", tt.name, expected, stripped)
+		}
+	}
+}
+
+func TestStripJPEGTrailingData(t *testing.T) {
+	plain := encodeJPEG(t, 16, 8)
+	stripped, err := stripJPEG(append(append([]byte(nil), plain...), "\xFF\xD8preview"...))
+	if err != nil || !bytes.Equal(stripped, plain) {
+		t.Errorf("expected the data after the end of image to be removed (%v)", err)
+	}
+}
+
+func TestStripJPEGInvalid(t *testing.T) {
+	plain := encodeJPEG(t, 16, 8)
+	for name, data := range map[string][]byte{
+		"empty":             nil,
+		"not a JPEG":        encodePNG(t, 16, 8),
+		"truncated":         plain[:len(plain)/2],
+		"truncated segment": withSegment(plain, 0xE1, []byte("Exif"))[:10],
+		"bad length":        append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01}, plain[2:]...),
+	} {
+		if _, err := stripJPEG(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	tests := []struct {
+		typ   string
+		chunk string
+		kept  bool
+	}{
+		{"tEXt", "Author\x00someone", false},
+		{"iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>", false},
+		{"eXIf", "MM\x00\x2a\x00\x00\x00\x08\x00\x00", false},
+		{"tIME", "\x07\xe7\x05\x01\x0a\x14\x1e", false},
+		{"prVt", "private", false},
+		{"iCCP", "Display P3\x00\x00" + iccProfile, true},
+		{"gAMA", "\x00\x00\xb1\x8f", true},
+		{"sRGB", "\x00", true},
+		{"pHYs", "\x00\x00\x0b\x13\x00\x00\x0b\x13\x01", true},
+	}
+	plain := encodePNG(t, 16, 8)
+	for _, tt := range tests {
+		expected := plain
+		if tt.kept {
+			expected = withChunk(plain, tt.typ, []byte(tt.chunk))
+		}
+		stripped, err := stripPNG(withChunk(plain, tt.typ, []byte(tt.chunk)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.typ, err)
+		} else if !bytes.Equal(stripped, expected) {
+			t.Errorf("%s: expected %q, got %q", tt.typ, expected, stripped)
+		}
+	}
+}
+
+func TestStripPNGInvalid(t *testing.T) {
+	plain := encodePNG(t, 16, 8)
+	badCRC := withChunk(plain, "tEXt", []byte("Author\x00someone"))
+	// The last byte of the checksum of the tEXt chunk
+	badCRC[len(pngSignature)+25+12+len("Author\x00someone")-1] ^= 0xFF
+	huge := append([]byte(nil), plain...)
+	binary.BigEndian.PutUint32(huge[len(pngSignature):], 0xFFFFFFFF)
+
+	for name, data := range map[string][]byte{
+		"empty":           nil,
+		"not a PNG":       encodeJPEG(t, 16, 8),
+		"truncated":       plain[:len(plain)-6],
+		"bad checksum":    badCRC,
+		"huge chunk":      huge,
+		"only the header": []byte(pngSignature),
+	} {
+		if _, err := stripPNG(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestProcessKeepsICCProfile(t *testing.T) {
+	tiff := buildTIFF(binary.BigEndian, []exifEntry{{tagOrientation, uint16(8)}}, nil)
+	icc := jpegICCPrefix + "\x01\x01" + iccProfile
+	jpegPhoto := withSegment(withEXIF(encodeJPEG(t, 20, 10), tiff), 0xE2, []byte(icc))
+	pngPhoto := withChunk(withChunk(encodePNG(t, 20, 10), "eXIf", tiff), "iCCP", []byte("P3\x00\x00"+iccProfile))
+
+	for format, data := range map[string][]byte{"jpeg": jpegPhoto, "png": pngPhoto} {
+		// Rotated, so encoded again
+		photo, err := Process(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if photo.Width != 10 || photo.Height != 20 {
+			t.Errorf("%s: expected a 10x20 photo, got %dx%d", format, photo.Width, photo.Height)
+		}
+		if _, _, err := image.Decode(bytes.NewReader(photo.Data)); err != nil {
+			t.Errorf("%s: decoding the photo: %v", format, err)
+		}
+		if format == "jpeg" && !bytes.Equal(jpegICCProfile(photo.Data), jpegICCProfile(jpegPhoto)) {
+			t.Errorf("jpeg: expected the ICC profile to be kept")
+		}
+		if format == "png" && !bytes.Equal(pngICCProfile(photo.Data), pngICCProfile(pngPhoto)) {
+			t.Errorf("png: expected the ICC profile to be kept")
+		}
+		if findEXIF(photo.Data, format) != nil {
+			t.Errorf("%s: expected the EXIF data to be stripped", format)
+		}
+	}
+}
+
+// encodeGIF returns an animated GIF with the given frames, all of the given size
+func encodeGIF(t *testing.T, width, height, frames int, localPalette bool) []byte {
+	t.Helper()
+	animation := &gif.GIF{Config: image.Config{Width: width, Height: height}}
+	palette := color.Palette{color.White, color.Black}
+	if !localPalette {
+		animation.Config.ColorModel = palette
+	}
+	for i := 0; i < frames; i++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette))
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFFrames(t *testing.T) {
+	tests := []struct {
+		name         string
+		frames       int
+		localPalette bool
+	}{
+		{"single frame", 1, false},
+		{"animation", 5, false},
+		{"local color tables", 3, true},
+	}
+	for _, tt := range tests {
+		data := encodeGIF(t, 4, 4, tt.frames, tt.localPalette)
+		frames, err := gifFrames(data)
+		if err != nil || frames != tt.frames {
+			t.Errorf("%s: expected %d frames, got %d (%v)", tt.name, tt.frames, frames, err)
+		}
+		if _, err := gifFrames(data[:len(data)-1]); err == nil {
+			t.Errorf("%s: expected an error without the trailer", tt.name)
+		}
+	}
+
+	if _, err := gifFrames(encodePNG(t, 4, 4)); err == nil {
+		t.Error("expected an error for a PNG file")
+	}
+}
+
+func TestProcessGIFFrames(t *testing.T) {
+	// Each frame is as large as the logical screen once decoded
+	if _, err := Process(bytes.NewReader(encodeGIF(t, 4000, 4000, 2, false))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	photo, err := Process(bytes.NewReader(encodeGIF(t, 4, 4, 3, false)))
+	if err != nil || photo.ContentType != "image/gif" {
+		t.Errorf("expected a GIF photo, got %q (%v)", photo.ContentType, err)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+Package metrics collects application metrics and exposes them in the Prometheus text exposition format, without any
+dependency on the Prometheus client library.
+
+Metrics are created in a Registry, which is also the HTTP handler serving them:
+
+	registry := metrics.NewRegistry()
+	requests := registry.NewCounterVec("webapi_http_requests_total", "HTTP requests received.", "route", "code")
+	requests.Add(1, "GET /users", "200")
+
+	// Serve the metrics in /metrics
+	mux.Handle("/metrics", registry)
+
+Only counters, gauges and histograms are supported. Label values must be passed in the same order as the label names
+given when creating the metric.
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the histogram buckets for HTTP latencies, in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metric is implemented by all the metrics of a Registry
+type metric interface {
+	// write writes the samples of the metric in the text exposition format (without the HELP and TYPE lines)
+	write(w *bufio.Writer, name string)
+}
+
+type registered struct {
+	name, help, kind string
+	metric           metric
+}
+
+// Registry is a set of metrics. It's an http.Handler serving all the metrics in the text exposition format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []registered
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a metric to the registry. It panics if the name is already used, like expvar.Publish.
+func (r *Registry) register(name, help, kind string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.metrics {
+		if other.name == name {
+			panic("metrics: duplicate metric name " + name)
+		}
+	}
+	r.metrics = append(r.metrics, registered{name: name, help: help, kind: kind, metric: m})
+	sort.Slice(r.metrics, func(i, j int) bool { return r.metrics[i].name < r.metrics[j].name })
+}
+
+// ServeHTTP writes all the metrics in the text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	r.mu.Lock()
+	metrics := append([]registered(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeHelp(m.help), m.name, m.kind)
+		m.metric.write(bw, m.name)
+	}
+	_ = bw.Flush()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// NewGauge creates and registers a Gauge
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+// Add adds `v` (which can be negative) to the gauge
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w *bufio.Writer, name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeSample(w, name, "", g.value)
+}
+
+// funcMetric is a gauge or a counter whose value is read when the metrics are served
+type funcMetric func() float64
+
+// NewGaugeFunc registers a gauge whose value is returned by `fn`
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, "gauge", funcMetric(fn))
+}
+
+// NewCounterFunc registers a counter whose value is returned by `fn`. The value must never decrease.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, help, "counter", funcMetric(fn))
+}
+
+func (f funcMetric) write(w *bufio.Writer, name string) {
+	writeSample(w, name, "", f())
+}
+
+// CounterVec is a set of counters, one for each combination of label values
+type CounterVec struct {
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates and registers a CounterVec with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{labels: labels, values: make(map[string]float64)}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// Add adds `v` (which must not be negative) to the counter with the given label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w *bufio.Writer, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		writeSample(w, name, key, c.values[key])
+	}
+}
+
+// HistogramVec is a set of histograms, one for each combination of label values
+type HistogramVec struct {
+	labels  []string
+	buckets []float64
+
+	mu         sync.Mutex
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	// counts[i] is the number of observations in the bucket i, not cumulative. The last one is the +Inf bucket.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a HistogramVec with the given bucket upper bounds (in increasing order) and
+// label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{labels: labels, buckets: buckets, histograms: make(map[string]*histogram)}
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+// Observe adds the value `v` to the histogram with the given label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.histograms[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.histograms[key] = hist
+	}
+	hist.counts[i]++
+	hist.count++
+	hist.sum += v
+}
+
+func (h *HistogramVec) write(w *bufio.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.histograms) {
+		hist := h.histograms[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hist.counts[i]
+			writeSample(w, name+"_bucket", joinLabels(key, `le="`+formatFloat(le)+`"`), float64(cumulative))
+		}
+		writeSample(w, name+"_bucket", joinLabels(key, `le="+Inf"`), float64(hist.count))
+		writeSample(w, name+"_sum", key, hist.sum)
+		writeSample(w, name+"_count", key, float64(hist.count))
+	}
+}
+
+// writeSample writes a line of the exposition format. `labels` is a list of labels already formatted by formatLabels.
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	_, _ = w.WriteString(name)
+	if labels != "" {
+		_, _ = w.WriteString("{" + labels + "}")
+	}
+	_, _ = w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// formatLabels formats the labels as `name="value",...`. Missing values are empty.
+func formatLabels(names, values []string) string {
+	parts := make([]string, len(names))
+	for i, name := range names {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		parts[i] = name + `="` + escapeLabelValue(value) + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+// joinLabels joins two lists of formatted labels
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// sortedKeys returns the keys of a map of metrics by labels, sorted
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]float64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*histogram:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the metrics of the registry, as served to Prometheus
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", contentType)
+	}
+	return rec.Body.String()
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "HTTP requests.", "route", "code")
+	inFlight := r.NewGauge("in_flight", "Requests being served.")
+	r.NewGaugeFunc("goroutines", "Goroutines.", func() float64 { return 7 })
+	r.NewCounterFunc("bytes_total", "Bytes stored.\nWith a \\ backslash.", func() float64 { return 1e9 })
+
+	requests.Add(1, "GET /users", "200")
+	requests.Add(2, "GET /users", "200")
+	requests.Add(1, `GET /"quoted"`, "404")
+	inFlight.Add(3)
+	inFlight.Add(-1.5)
+
+	// Metrics are sorted by name, and samples by labels
+	expected := `# HELP bytes_total Bytes stored.\nWith a \\ backslash.
+# TYPE bytes_total counter
+bytes_total 1e+09
+# HELP goroutines Goroutines.
+# TYPE goroutines gauge
+goroutines 7
+# HELP in_flight Requests being served.
+# TYPE in_flight gauge
+in_flight 1.5
+# HELP requests_total HTTP requests.
+# TYPE requests_total counter
+requests_total{route="GET /\"quoted\"",code="404"} 1
+requests_total{route="GET /users",code="200"} 3
+`
+	if got := scrape(t, r); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("duration_seconds", "Latency.", []float64{0.1, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v, "GET /a")
+	}
+	h.Observe(0.2, "GET /b")
+
+	// Buckets are cumulative, and their upper bound is inclusive
+	expected := `# HELP duration_seconds Latency.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="GET /a",le="0.1"} 2
+duration_seconds_bucket{route="GET /a",le="1"} 3
+duration_seconds_bucket{route="GET /a",le="+Inf"} 4
+duration_seconds_sum{route="GET /a"} 3.65
+duration_seconds_count{route="GET /a"} 4
+duration_seconds_bucket{route="GET /b",le="0.1"} 0
+duration_seconds_bucket{route="GET /b",le="1"} 1
+duration_seconds_bucket{route="GET /b",le="+Inf"} 1
+duration_seconds_sum{route="GET /b"} 0.2
+duration_seconds_count{route="GET /b"} 1
+`
+	if got := scrape(t, r); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		names, values []string
+		expected      string
+	}{
+		{nil, nil, ""},
+		{[]string{"a"}, []string{"x"}, `a="x"`},
+		{[]string{"a", "b"}, []string{"x", "y"}, `a="x",b="y"`},
+		// Missing values are empty, extra ones are ignored
+		{[]string{"a", "b"}, []string{"x"}, `a="x",b=""`},
+		{[]string{"a"}, []string{"x", "y"}, `a="x"`},
+		{[]string{"a"}, []string{"back\\slash \"quote\"\nline"}, `a="back\\slash \"quote\"\nline"`},
+	}
+	for _, tt := range tests {
+		if got := formatLabels(tt.names, tt.values); got != tt.expected {
+			t.Errorf("formatLabels(%q, %q) = %s; expected %s", tt.names, tt.values, got, tt.expected)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{0, "0"},
+		{-2, "-2"},
+		{0.25, "0.25"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.value); got != tt.expected {
+			t.Errorf("formatFloat(%v) = %s; expected %s", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestDuplicateName(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("metric", "A gauge.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering the same name twice to panic")
+		}
+	}()
+	r.NewCounterVec("metric", "A counter.")
+}
@@ -5,4 +5,23 @@ const instance = axios.create({
 	timeout: 1000 * 5
 });
 
+// Send the session token obtained at login with every request
+instance.interceptors.request.use(config => {
+	const token = localStorage.getItem('token');
+	if (token) {
+		config.headers.Authorization = `Bearer ${token}`;
+	}
+	return config;
+});
+
+// imageURL returns the URL to show a photo. Uploaded photos are served by the API with relative URLs (e.g.
+// /images/1/raw), which are resolved against the API base URL instead of the URL of the page. Photos posted by URL are
+// left as they are.
+export function imageURL(url) {
+	if (!url || /^([a-z][a-z0-9+.-]*:|\/\/)/i.test(url)) {
+		return url;
+	}
+	return (instance.defaults.baseURL || '').replace(/\/+$/, '') + '/' + url.replace(/^\/+/, '');
+}
+
 export default instance;